@@ -0,0 +1,275 @@
+package cgroup
+
+/*
+This package places processes into cgroup v2 groups limited in memory, cpu and
+pids. The groups are created under the operator own cgroup, which must be
+delegated to the operator, eg: `Delegate=yes` in its systemd unit.
+*/
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	mountPath = "/sys/fs/cgroup"
+
+	// cpuPeriod is the cpu.max period in microseconds.
+	cpuPeriod = 100000
+
+	controllers = "+cpu +memory +pids"
+)
+
+// Errors
+var (
+	ErrUnsupported = errors.New("cgroup: cgroup v2 is not available")
+)
+
+var (
+	setupOnce sync.Once
+	rootPath  string // parent of every group
+	setupErr  error
+)
+
+// Limits represents the resources a group can use. Zero values mean no limit.
+type Limits struct {
+	MemoryMax int64 // bytes
+	CPUWeight int   // between 1 and 10000, 100 by default
+	CPUQuota  int   // percent of a single cpu, eg: 50
+	PidsMax   int
+}
+
+// Group represents a cgroup whose processes share the same limits.
+type Group struct {
+	Name   string
+	Limits Limits
+
+	mu       sync.Mutex // guards dir and oomKills
+	dir      string     // empty until the group is opened
+	oomKills int        // oom_kill count when the group was last opened
+}
+
+// New returns a group with the given limits. The group is created when it's
+// first opened.
+func New(name string, l Limits) *Group {
+	return &Group{Name: name, Limits: l}
+}
+
+// Open creates the group if needed, applies its limits and returns its
+// directory, so a process can be started directly in the group, eg: with the
+// syscall.SysProcAttr CgroupFD. Processes forked by it belong to the group
+// too. The caller must close the directory once the process started.
+func (g *Group) Open() (*os.File, error) {
+	root, err := setup()
+	if err != nil {
+		return nil, err
+	}
+
+	dir := filepath.Join(root, g.Name)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	if err := g.apply(dir); err != nil {
+		return nil, err
+	}
+
+	kills, err := readOOMKills(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	g.mu.Lock()
+	g.dir = dir
+	g.oomKills = kills
+	g.mu.Unlock()
+
+	return os.Open(dir)
+}
+
+// OOMKilled returns true if the OOM killer killed a process of the group since
+// it was last opened.
+func (g *Group) OOMKilled() bool {
+	g.mu.Lock()
+	dir, last := g.dir, g.oomKills
+	g.mu.Unlock()
+
+	if len(dir) == 0 {
+		return false
+	}
+
+	kills, err := readOOMKills(dir)
+	if err != nil {
+		return false
+	}
+
+	return kills > last
+}
+
+// Remove deletes the group, if it was opened. It fails if the group still has
+// processes.
+func (g *Group) Remove() error {
+	g.mu.Lock()
+	dir := g.dir
+	g.mu.Unlock()
+
+	if len(dir) == 0 {
+		return nil
+	}
+
+	err := os.Remove(dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	return err
+}
+
+func (g *Group) apply(dir string) error {
+	memory, pids, cpu := "max", "max", "max"
+	if g.Limits.MemoryMax > 0 {
+		memory = strconv.FormatInt(g.Limits.MemoryMax, 10)
+	}
+	if g.Limits.PidsMax > 0 {
+		pids = strconv.Itoa(g.Limits.PidsMax)
+	}
+	if g.Limits.CPUQuota > 0 {
+		cpu = strconv.Itoa(g.Limits.CPUQuota * cpuPeriod / 100)
+	}
+
+	weight := 100
+	if g.Limits.CPUWeight > 0 {
+		weight = g.Limits.CPUWeight
+	}
+
+	files := []struct{ name, value string }{
+		{"memory.max", memory},
+		{"pids.max", pids},
+		{"cpu.max", fmt.Sprintf("%s %d", cpu, cpuPeriod)},
+		{"cpu.weight", strconv.Itoa(weight)},
+	}
+	for _, f := range files {
+		if err := write(dir, f.name, f.value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// setup prepares the operator cgroup to hold the groups, only once, when the
+// first group is opened. The operator cgroup is left untouched if no group is
+// ever opened.
+func setup() (string, error) {
+	setupOnce.Do(func() {
+		rootPath, setupErr = setupRoot()
+	})
+
+	return rootPath, setupErr
+}
+
+// setupRoot moves the operator processes into a leaf group, since cgroup v2
+// does not allow processes in groups that distribute resources to their
+// children, and enables the controllers for the groups parent.
+func setupRoot() (string, error) {
+	if _, err := os.Stat(filepath.Join(mountPath, "cgroup.controllers")); err != nil {
+		return "", ErrUnsupported
+	}
+
+	own, err := ownCgroup()
+	if err != nil {
+		return "", err
+	}
+
+	root := filepath.Join(mountPath, own)
+
+	// the root cgroup is the only one allowed to have both processes and
+	// controllers enabled, and its processes are not only the operator ones.
+	if own != "/" {
+		if err := moveProcesses(root, filepath.Join(root, "operator")); err != nil {
+			return "", err
+		}
+	}
+
+	if err := write(root, "cgroup.subtree_control", controllers); err != nil {
+		return "", err
+	}
+
+	services := filepath.Join(root, "services")
+	if err := os.MkdirAll(services, 0755); err != nil {
+		return "", err
+	}
+
+	if err := write(services, "cgroup.subtree_control", controllers); err != nil {
+		return "", err
+	}
+
+	return services, nil
+}
+
+// moveProcesses moves every process of the src group into the dst group,
+// creating it if needed.
+func moveProcesses(src, dst string) error {
+	if err := os.MkdirAll(dst, 0755); err != nil {
+		return err
+	}
+
+	procs, err := ioutil.ReadFile(filepath.Join(src, "cgroup.procs"))
+	if err != nil {
+		return err
+	}
+
+	for _, pid := range strings.Fields(string(procs)) {
+		if err := write(dst, "cgroup.procs", pid); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ownCgroup returns the operator cgroup path, relative to the cgroup mount.
+func ownCgroup() (string, error) {
+	b, err := ioutil.ReadFile("/proc/self/cgroup")
+	if err != nil {
+		return "", err
+	}
+
+	// the cgroup v2 entry has the format: 0::/path
+	for _, line := range strings.Split(string(b), "\n") {
+		if strings.HasPrefix(line, "0::") {
+			return strings.TrimPrefix(line, "0::"), nil
+		}
+	}
+
+	return "", ErrUnsupported
+}
+
+func readOOMKills(dir string) (int, error) {
+	f, err := os.Open(filepath.Join(dir, "memory.events"))
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && fields[0] == "oom_kill" {
+			return strconv.Atoi(fields[1])
+		}
+	}
+
+	return 0, scanner.Err()
+}
+
+func write(dir, file, value string) error {
+	return ioutil.WriteFile(filepath.Join(dir, file), []byte(value), 0644)
+}
@@ -3,11 +3,13 @@ package command
 import (
 	"encoding/json"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"strings"
 	"sync"
 	"syscall"
+	"time"
 
 	"github.com/WiseGrowth/go-wisebot/logger"
 )
@@ -24,8 +26,26 @@ const (
 	StatusUpdating     Status = "updating"
 	StatusDone         Status = "succeed"
 	StatusStopped      Status = "stopped"
+	// StatusCrashLoop means the command exited too many times in a short
+	// period, so it's quarantined until someone clears it.
+	StatusCrashLoop Status = "crash-loop"
+	// StatusBlocked means the command was not started because a unit it
+	// requires is not up.
+	StatusBlocked Status = "blocked"
+	// StatusOOMKilled means the command was killed because it ran out of
+	// memory.
+	StatusOOMKilled Status = "oom-killed"
 )
 
+// DefaultStopTimeout is how long Stop waits for the command to exit after each
+// signal before escalating to the next one.
+const DefaultStopTimeout = 10 * time.Second
+
+// outputWaitDelay is how long the command output is still copied after the
+// process exits. Children that outlive it, eg: daemonized ones, keep the
+// output open, and the command would never be reported as exited otherwise.
+const outputWaitDelay = 2 * time.Second
+
 // Command represents a os level command, which can also receive a logger file
 // in order to dump the output to it.
 type Command struct {
@@ -37,10 +57,22 @@ type Command struct {
 	status   Status
 	execName string
 	execArgs []string
+	output   io.Writer
+	tail     *Tail
+
+	stopTimeout time.Duration
+	stopSignal  os.Signal // signal that ended the process when stopping it
+
+	privileges *Privileges
+	cgroupFD   int // -1 if the process starts in the operator cgroup
+	env        []string
+	dir        string
 
 	exitError chan error
 
-	mu sync.RWMutex // guards command status
+	mu      sync.RWMutex     // guards command status, process and state
+	process *os.Process      // set once started
+	state   *os.ProcessState // set once exited
 }
 
 // Clone clones the command by instantiate a new one with same attributes
@@ -50,14 +82,98 @@ func (c *Command) Clone() *Command {
 	cmd := NewCommand(c.execName, c.execArgs...)
 	cmd.Version = c.Version
 	cmd.Finish = c.Finish
+	cmd.output = c.output
+	cmd.tail = c.tail
+	cmd.stopTimeout = c.stopTimeout
+	cmd.setupOutput()
+	cmd.SetPrivileges(c.privileges)
+	cmd.SetEnv(c.env)
+	cmd.SetDir(c.dir)
 	return cmd
 }
 
+// SetEnv sets the command environment. If env is nil, the command uses the
+// operator environment. It must be called before starting the command.
+func (c *Command) SetEnv(env []string) {
+	c.env = env
+	c.Cmd.Env = env
+}
+
+// SetCgroupFD starts the process directly in the cgroup of the given directory
+// descriptor, so neither the process nor its children ever run outside of it.
+// The descriptor must stay open until the command started. It's only
+// supported on linux, and it's not kept by Clone.
+func (c *Command) SetCgroupFD(fd int) {
+	c.cgroupFD = fd
+	c.Cmd.SysProcAttr = sysProcAttr(c.privileges, fd)
+}
+
+// SetDir sets the command working directory. If dir is empty, the command runs
+// in the operator working directory. It must be called before starting the
+// command.
+func (c *Command) SetDir(dir string) {
+	c.dir = dir
+	c.Cmd.Dir = dir
+}
+
+// SetStopTimeout sets how long Stop waits for the command to exit after each
+// signal before escalating to the next one.
+func (c *Command) SetStopTimeout(d time.Duration) {
+	c.stopTimeout = d
+}
+
+// StopSignal returns the signal that finally ended the process when it was
+// stopped. It returns nil if the command was not stopped by a signal.
+func (c *Command) StopSignal() os.Signal {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.stopSignal
+}
+
+// SetOutput sets the writer where the command stdout and stderr are dumped.
+// It must be called before starting the command.
+func (c *Command) SetOutput(w io.Writer) {
+	c.output = w
+	c.setupOutput()
+}
+
+// SetTail replaces the buffer that keeps the last command output lines. It
+// must be called before starting the command.
+func (c *Command) SetTail(t *Tail) {
+	c.tail = t
+	c.setupOutput()
+}
+
+// Tail returns the last n output lines. The lines are kept across clones, so
+// the output of a crashed command is still available after restarting it.
+func (c *Command) Tail(n int) []string {
+	return c.tail.Lines(n)
+}
+
+func (c *Command) setupOutput() {
+	w := outputWriter{c.tail}
+	if c.output != nil {
+		w = append(w, c.output)
+	}
+
+	c.Cmd.Stdout = w
+	c.Cmd.Stderr = w
+}
+
 // Updater knows how to update the codebase of a specific command codebase.
 type Updater interface {
 	Update() (newVersion string, err error)
 }
 
+// UpdaterFunc is an adapter to use ordinary functions as updaters.
+type UpdaterFunc func() (newVersion string, err error)
+
+// Update calls f().
+func (f UpdaterFunc) Update() (string, error) {
+	return f()
+}
+
 // MarshalJSON implements the json interface
 func (c *Command) MarshalJSON() ([]byte, error) {
 	return json.Marshal(&struct {
@@ -110,11 +226,12 @@ func (c *Command) Status() Status {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
 
-	if c.status == StatusStopped {
+	switch c.status {
+	case StatusStopped, StatusCrashLoop, StatusBlocked, StatusOOMKilled:
 		return c.status
 	}
 
-	if ps := c.Cmd.ProcessState; ps != nil {
+	if ps := c.state; ps != nil {
 		if ps.Success() {
 			return StatusDone
 		}
@@ -125,37 +242,100 @@ func (c *Command) Status() Status {
 	return c.status
 }
 
-// Stop stops the command and closes the log file if exists.
+// Stop stops the command by sending an interrupt signal to the process. If
+// the process does not exit within the stop timeout, a SIGTERM and then a
+// SIGKILL are sent to its whole process group. The signal that ended the
+// process can be retrieved with StopSignal.
 func (c *Command) Stop() error {
 	log := logger.GetLogger()
-	if c.status == StatusStopped {
+
+	c.mu.RLock()
+	status, process, ps := c.status, c.process, c.state
+	c.mu.RUnlock()
+
+	if status == StatusStopped {
 		return fmt.Errorf("commands: command %q is already stopped", c.Slug())
 	}
 
-	if c.status == StatusCrashed || c.status == StatusBootingError {
+	if status == StatusCrashed || status == StatusBootingError || status == StatusOOMKilled {
 		return nil
 	}
 
-	if c.Cmd.Process == nil {
-		log.Debug("Stopped command when c.Cmd.Process was nil")
+	if process == nil {
+		log.Debug("Stopped command when the process was not started")
 		return nil
 	}
 
-	// the ProcessState only exists if either the process exited, or we called
-	// Run or Wait functions.
-	ps := c.Cmd.ProcessState
+	// the process state is only recorded once the process exited.
 	if ps != nil && ps.Exited() {
-		log.Debug("Stopped command when c.Cmd.ProcessState is set and process.Exited() is true")
+		log.Debug("Stopped command when the process state is set and process.Exited() is true")
 		return nil
 	}
 
-	if err := c.Cmd.Process.Signal(os.Interrupt); err != nil {
+	// The status is set before sending the signal, so whoever receives the
+	// command exit error knows the command was stopped on purpose.
+	oldStatus := c.Status()
+	c.SetStatus(StatusStopped)
+	if err := process.Signal(os.Interrupt); err != nil {
+		c.SetStatus(oldStatus)
 		return err
 	}
 
-	c.SetStatus(StatusStopped)
+	timeout := c.stopTimeout
+	if timeout <= 0 {
+		timeout = DefaultStopTimeout
+	}
+
+	// the command was started with Setpgid, so its pid is also the pgid.
+	pgid := process.Pid
+	sent := []os.Signal{os.Interrupt}
+	for _, sig := range []syscall.Signal{syscall.SIGTERM, syscall.SIGKILL} {
+		select {
+		case err := <-c.exitError:
+			return c.stopped(sent, err)
+		case <-time.After(timeout):
+		}
+
+		log.WithField("signal", sig.String()).Warn("Command did not exit in time, signaling its process group")
+		// a negative pid sends the signal to the whole process group.
+		if err := syscall.Kill(-pgid, sig); err != nil && err != syscall.ESRCH {
+			return err
+		}
+		sent = append(sent, sig)
+	}
 
-	return <-c.exitError
+	return c.stopped(sent, <-c.exitError)
+}
+
+// stopped records the signal that ended the command and filters the exit error
+// caused by it, since being killed by any of the signals sent is the expected
+// outcome. The exit can be noticed after a later signal was sent, while the
+// output of the children is still being copied, so the signal recorded is the
+// one in the exit status, or the last one sent if it exited on its own.
+func (c *Command) stopped(sent []os.Signal, exitErr error) error {
+	sig := sent[len(sent)-1]
+	defer func() {
+		c.mu.Lock()
+		c.stopSignal = sig
+		c.mu.Unlock()
+	}()
+
+	if exitErr == nil {
+		return nil
+	}
+
+	if e, ok := exitErr.(*exec.ExitError); ok {
+		if ws, ok := e.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+			for _, s := range sent {
+				if ws.Signal() == s {
+					sig = s
+					return nil
+				}
+			}
+		}
+	}
+
+	return exitErr
 }
 
 // Wait only proxies the function call to the  os.Command.Wait function.
@@ -171,23 +351,80 @@ func (c *Command) Start() error {
 		return err
 	}
 
+	// the process and its exit state are recorded under the lock, since the
+	// exec.Cmd ones are written by Start and Wait without synchronization.
+	c.mu.Lock()
+	c.process = c.Cmd.Process
+	c.status = StatusRunning
+	c.mu.Unlock()
+
 	go func() {
 		err := c.Wait()
-		if err != nil {
-			c.SetStatus(StatusCrashed)
+		// the process exited successfully but its children kept the output
+		// open, they are not the command.
+		if err == exec.ErrWaitDelay {
+			err = nil
+		}
+
+		c.mu.Lock()
+		c.state = c.Cmd.ProcessState
+		if err != nil && c.status != StatusStopped {
+			c.status = StatusCrashed
 		}
+		c.mu.Unlock()
+
 		c.exitError <- err
 		c.Finish <- err
 	}()
 
-	c.SetStatus(StatusRunning)
-
 	return nil
 }
 
-// Success just proxies the function call to the command.ProcessState struct.
+// ExitState returns the process exit code, or the signal that terminated it.
+// If the process has not exited, it returns a -1 code and a nil signal.
+func (c *Command) ExitState() (code int, signal os.Signal) {
+	c.mu.RLock()
+	ps := c.state
+	c.mu.RUnlock()
+
+	if ps == nil {
+		return -1, nil
+	}
+
+	if ws, ok := ps.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+		return -1, ws.Signal()
+	}
+
+	return ps.ExitCode(), nil
+}
+
+// Pid returns the process id, or 0 if the process is not running.
+func (c *Command) Pid() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if c.process == nil || c.state != nil || c.status != StatusRunning {
+		return 0
+	}
+
+	return c.process.Pid
+}
+
+// Alive returns true if the process was started and has not exited yet,
+// whatever the command status is, eg: while the command is being updated.
+func (c *Command) Alive() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.process != nil && c.state == nil
+}
+
+// Success returns true if the process exited successfully.
 func (c *Command) Success() bool {
-	return c.Cmd.ProcessState.Success()
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.state != nil && c.state.Success()
 }
 
 // NewCommand returns an initalized command pointer.
@@ -198,13 +435,13 @@ func NewCommand(name string, args ...string) *Command {
 		status:    StatusIdle,
 		execName:  name,
 		execArgs:  args,
+		tail:      NewTail(DefaultTailLines),
 		exitError: make(chan error, 1),
+		cgroupFD:  -1,
 	}
-
-	cmd.Cmd.SysProcAttr = &syscall.SysProcAttr{
-		Setpgid: true,
-		Pgid:    0,
-	}
+	cmd.Cmd.WaitDelay = outputWaitDelay
+	cmd.setupOutput()
+	cmd.SetPrivileges(nil)
 
 	return cmd
 }
@@ -0,0 +1,100 @@
+package command
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	// DefaultHookTimeout is how long a hook can run if it has no timeout.
+	DefaultHookTimeout = 30 * time.Second
+
+	// hookOutputLines is the number of output lines kept when a hook fails.
+	hookOutputLines = 50
+)
+
+// Hook represents a command run around the lifecycle of another command, eg:
+// a database migration before starting it.
+type Hook struct {
+	Name    string
+	Args    []string
+	Timeout time.Duration
+}
+
+// Slug combines the hook name and args in order to return a verbose
+// identifier.
+func (h Hook) Slug() string {
+	return strings.TrimSpace(fmt.Sprintf("%s %s", h.Name, strings.Join(h.Args, " ")))
+}
+
+// HookError is returned when a hook fails. It contains the last hook output
+// lines.
+type HookError struct {
+	Hook   string    `json:"hook"`
+	Reason string    `json:"error"`
+	Output []string  `json:"output"`
+	At     time.Time `json:"at"`
+}
+
+func (e *HookError) Error() string {
+	return fmt.Sprintf("commands: hook %q failed: %s", e.Hook, e.Reason)
+}
+
+// Exec returns a process that runs with the command environment, working
+// directory and privileges, in its own process group, eg: a hook or a probe.
+func (c *Command) Exec(name string, args ...string) *exec.Cmd {
+	cmd := exec.Command(name, args...)
+	cmd.Dir = c.dir
+	cmd.Env = c.env
+	cmd.SysProcAttr = sysProcAttr(c.privileges, -1)
+
+	return cmd
+}
+
+// RunHook runs the hook with the command environment, working directory and
+// privileges, and waits for it to exit. The hook output is also dumped to the
+// command output. If the hook fails or does not exit within its timeout, it
+// returns a *HookError. On timeout, the hook process group is killed, so its
+// children don't outlive it.
+func (c *Command) RunHook(h Hook) error {
+	timeout := h.Timeout
+	if timeout <= 0 {
+		timeout = DefaultHookTimeout
+	}
+
+	out := NewTail(hookOutputLines)
+	w := outputWriter{out, c.tail}
+	if c.output != nil {
+		w = append(w, c.output)
+	}
+
+	hook := c.Exec(h.Name, h.Args...)
+	hook.Stdout = w
+	hook.Stderr = w
+
+	err := hook.Start()
+	if err == nil {
+		// the hook runs in its own process group, so its pid is also the pgid.
+		timer := time.AfterFunc(timeout, func() {
+			syscall.Kill(-hook.Process.Pid, syscall.SIGKILL)
+		})
+		err = hook.Wait()
+		if !timer.Stop() {
+			err = fmt.Errorf("timed out after %s", timeout)
+		}
+	}
+
+	if err != nil {
+		return &HookError{
+			Hook:   h.Slug(),
+			Reason: err.Error(),
+			Output: out.Lines(0),
+			At:     time.Now(),
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,85 @@
+package command
+
+import (
+	"fmt"
+	"strings"
+	"syscall"
+)
+
+// Privileges represents the user, groups and linux capabilities a command runs
+// with. Commands without privileges run with the operator ones.
+type Privileges struct {
+	// Credential is the user and groups the command runs as. If nil, the
+	// command runs as the operator user.
+	Credential *syscall.Credential
+	// Capabilities are raised as ambient capabilities, so the command keeps
+	// them even when running as an unprivileged user. The operator must have
+	// them too. They are only supported on linux.
+	Capabilities []uintptr
+}
+
+// capabilities maps the linux capability names to their numbers, as defined
+// in linux/capability.h.
+var capabilities = map[string]uintptr{
+	"CAP_CHOWN":            0,
+	"CAP_DAC_OVERRIDE":     1,
+	"CAP_DAC_READ_SEARCH":  2,
+	"CAP_FOWNER":           3,
+	"CAP_FSETID":           4,
+	"CAP_KILL":             5,
+	"CAP_SETGID":           6,
+	"CAP_SETUID":           7,
+	"CAP_SETPCAP":          8,
+	"CAP_LINUX_IMMUTABLE":  9,
+	"CAP_NET_BIND_SERVICE": 10,
+	"CAP_NET_BROADCAST":    11,
+	"CAP_NET_ADMIN":        12,
+	"CAP_NET_RAW":          13,
+	"CAP_IPC_LOCK":         14,
+	"CAP_IPC_OWNER":        15,
+	"CAP_SYS_MODULE":       16,
+	"CAP_SYS_RAWIO":        17,
+	"CAP_SYS_CHROOT":       18,
+	"CAP_SYS_PTRACE":       19,
+	"CAP_SYS_PACCT":        20,
+	"CAP_SYS_ADMIN":        21,
+	"CAP_SYS_BOOT":         22,
+	"CAP_SYS_NICE":         23,
+	"CAP_SYS_RESOURCE":     24,
+	"CAP_SYS_TIME":         25,
+	"CAP_SYS_TTY_CONFIG":   26,
+	"CAP_MKNOD":            27,
+	"CAP_LEASE":            28,
+	"CAP_AUDIT_WRITE":      29,
+	"CAP_AUDIT_CONTROL":    30,
+	"CAP_SETFCAP":          31,
+	"CAP_MAC_OVERRIDE":     32,
+	"CAP_MAC_ADMIN":        33,
+	"CAP_SYSLOG":           34,
+	"CAP_WAKE_ALARM":       35,
+	"CAP_BLOCK_SUSPEND":    36,
+	"CAP_AUDIT_READ":       37,
+}
+
+// ParseCapability returns the number of the given linux capability. The name
+// is case insensitive and the `CAP_` prefix is optional, eg: net_admin.
+func ParseCapability(name string) (uintptr, error) {
+	key := strings.ToUpper(name)
+	if !strings.HasPrefix(key, "CAP_") {
+		key = "CAP_" + key
+	}
+
+	c, ok := capabilities[key]
+	if !ok {
+		return 0, fmt.Errorf("commands: unknown capability %q", name)
+	}
+
+	return c, nil
+}
+
+// SetPrivileges sets the user, groups and capabilities the command runs with.
+// It must be called before starting the command.
+func (c *Command) SetPrivileges(p *Privileges) {
+	c.privileges = p
+	c.Cmd.SysProcAttr = sysProcAttr(p, c.cgroupFD)
+}
@@ -0,0 +1,25 @@
+package command
+
+import "syscall"
+
+// sysProcAttr starts the process in its own process group, so it can be
+// stopped along with its children, and applies the given privileges. If
+// cgroupFD is not negative, the process is created in that cgroup.
+func sysProcAttr(p *Privileges, cgroupFD int) *syscall.SysProcAttr {
+	attr := &syscall.SysProcAttr{
+		Setpgid: true,
+		Pgid:    0,
+	}
+
+	if cgroupFD >= 0 {
+		attr.UseCgroupFD = true
+		attr.CgroupFD = cgroupFD
+	}
+
+	if p != nil {
+		attr.Credential = p.Credential
+		attr.AmbientCaps = p.Capabilities
+	}
+
+	return attr
+}
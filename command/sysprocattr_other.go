@@ -0,0 +1,22 @@
+//go:build !linux
+// +build !linux
+
+package command
+
+import "syscall"
+
+// sysProcAttr starts the process in its own process group, so it can be
+// stopped along with its children, and applies the given privileges.
+// Capabilities and cgroups are ignored since they only exist on linux.
+func sysProcAttr(p *Privileges, cgroupFD int) *syscall.SysProcAttr {
+	attr := &syscall.SysProcAttr{
+		Setpgid: true,
+		Pgid:    0,
+	}
+
+	if p != nil {
+		attr.Credential = p.Credential
+	}
+
+	return attr
+}
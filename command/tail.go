@@ -0,0 +1,105 @@
+package command
+
+import (
+	"bytes"
+	"io"
+	"sync"
+)
+
+const (
+	// DefaultTailLines is the number of output lines a command keeps in memory.
+	DefaultTailLines = 500
+
+	// maxLineLength is the max length of a line kept by Tail. Longer lines are
+	// split, so output without newlines does not grow the buffer forever.
+	maxLineLength = 4096
+)
+
+// Tail is an io.Writer that keeps the last lines written to it in a ring
+// buffer.
+type Tail struct {
+	mu      sync.Mutex // guards lines, next, full and partial
+	lines   []string
+	next    int    // index where the next line is written
+	full    bool   // indicates if the ring buffer was filled at least once
+	partial []byte // last line until receiving its newline
+}
+
+// NewTail returns a Tail that keeps the last size lines.
+func NewTail(size int) *Tail {
+	if size < 1 {
+		size = 1
+	}
+
+	return &Tail{lines: make([]string, size)}
+}
+
+// Write implements the io.Writer interface. It never returns an error.
+func (t *Tail) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	b := p
+	for len(b) > 0 {
+		i := bytes.IndexByte(b, '\n')
+		if i < 0 {
+			t.partial = append(t.partial, b...)
+			if len(t.partial) >= maxLineLength {
+				t.push(string(t.partial))
+				t.partial = t.partial[:0]
+			}
+			break
+		}
+
+		t.partial = append(t.partial, b[:i]...)
+		t.push(string(t.partial))
+		t.partial = t.partial[:0]
+		b = b[i+1:]
+	}
+
+	return len(p), nil
+}
+
+// Lines returns the last n lines, from the oldest to the newest. If n is less
+// than 1 or greater than the buffer size, it returns every kept line.
+func (t *Tail) Lines(n int) []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	count := t.next
+	if t.full {
+		count = len(t.lines)
+	}
+
+	if n < 1 || n > count {
+		n = count
+	}
+
+	lines := make([]string, n)
+	start := t.next - n
+	for i := range lines {
+		lines[i] = t.lines[(start+i+len(t.lines))%len(t.lines)]
+	}
+
+	return lines
+}
+
+func (t *Tail) push(line string) {
+	t.lines[t.next] = line
+	t.next = (t.next + 1) % len(t.lines)
+	if t.next == 0 {
+		t.full = true
+	}
+}
+
+// outputWriter writes to every writer ignoring its errors, so a failing log
+// file doesn't break the command output pipe.
+type outputWriter []io.Writer
+
+func (ow outputWriter) Write(p []byte) (int, error) {
+	for _, w := range ow {
+		w.Write(p)
+	}
+
+	return len(p), nil
+}
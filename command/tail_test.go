@@ -0,0 +1,41 @@
+package command
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTail(t *testing.T) {
+	tests := []struct {
+		name   string
+		size   int
+		writes []string
+		n      int
+		want   []string
+	}{
+		{"empty", 3, nil, 0, []string{}},
+		{"lines", 3, []string{"a\nb\n"}, 0, []string{"a", "b"}},
+		{"last lines", 3, []string{"a\nb\nc\n"}, 2, []string{"b", "c"}},
+		{"more lines than kept", 3, []string{"a\nb\n", "c\nd\ne\n"}, 5, []string{"c", "d", "e"}},
+		{"ring buffer wraps", 3, []string{"a\nb\nc\nd\n"}, 2, []string{"c", "d"}},
+		{"line split across writes", 3, []string{"a\nb", "c", "d\n"}, 0, []string{"a", "bcd"}},
+		{"line without newline is not kept", 3, []string{"a\nb"}, 0, []string{"a"}},
+		{"empty lines", 3, []string{"\n\na\n"}, 0, []string{"", "", "a"}},
+		{"size less than 1 keeps a line", 0, []string{"a\nb\n"}, 0, []string{"b"}},
+		{"long lines are split", 3, []string{strings.Repeat("x", maxLineLength), "y\n"}, 0, []string{strings.Repeat("x", maxLineLength), "y"}},
+	}
+
+	for _, tt := range tests {
+		tail := NewTail(tt.size)
+		for _, s := range tt.writes {
+			if n, err := tail.Write([]byte(s)); n != len(s) || err != nil {
+				t.Errorf("%s: Write(%q) = %d, %v, want %d, nil", tt.name, s, n, err, len(s))
+			}
+		}
+
+		if got := tail.Lines(tt.n); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: Lines(%d) = %q, want %q", tt.name, tt.n, got, tt.want)
+		}
+	}
+}
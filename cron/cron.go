@@ -0,0 +1,203 @@
+package cron
+
+/*
+This package parses cron schedules with the standard five fields: minute, hour,
+day of month, month and day of week. Fields accept `*`, values, ranges (1-5),
+lists (1,15) and steps over ranges, `*` or a start value up to the maximum
+(0-30/5, 10/5). Sunday is both 0 and 7. The @hourly, @daily, @midnight,
+@weekly, @monthly, @yearly and @annually descriptors are also accepted.
+*/
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxYears is how far Next looks for a matching time.
+const maxYears = 5
+
+var descriptors = map[string]string{
+	"@hourly":   "0 * * * *",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@weekly":   "0 0 * * 0",
+	"@monthly":  "0 0 1 * *",
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+}
+
+type field struct {
+	name     string
+	min, max int
+}
+
+var fields = []field{
+	{"minute", 0, 59},
+	{"hour", 0, 23},
+	{"day of month", 1, 31},
+	{"month", 1, 12},
+	{"day of week", 0, 7},
+}
+
+// Schedule represents a parsed cron schedule. Each field is a bit set of the
+// matching values.
+type Schedule struct {
+	spec string
+
+	minute, hour, dom, month, dow uint64
+
+	// standard cron matches either the day of month or the day of week when
+	// both are restricted. Like in vixie cron, fields starting with `*`, eg:
+	// */2, are not restricted.
+	domStar, dowStar bool
+}
+
+// Parse parses the cron spec.
+func Parse(spec string) (*Schedule, error) {
+	expanded := strings.TrimSpace(spec)
+	if d, ok := descriptors[expanded]; ok {
+		expanded = d
+	}
+
+	parts := strings.Fields(expanded)
+	if len(parts) != len(fields) {
+		return nil, fmt.Errorf("cron: schedule %q must have %d fields", spec, len(fields))
+	}
+
+	sets := make([]uint64, len(fields))
+	for i, f := range fields {
+		set, err := parseField(parts[i], f)
+		if err != nil {
+			return nil, fmt.Errorf("cron: schedule %q: %s", spec, err.Error())
+		}
+		sets[i] = set
+	}
+
+	// 7 is also sunday.
+	dow := sets[4]
+	if dow&(1<<7) != 0 {
+		dow |= 1
+	}
+
+	return &Schedule{
+		spec:    spec,
+		minute:  sets[0],
+		hour:    sets[1],
+		dom:     sets[2],
+		month:   sets[3],
+		dow:     dow,
+		domStar: strings.HasPrefix(parts[2], "*"),
+		dowStar: strings.HasPrefix(parts[4], "*"),
+	}, nil
+}
+
+// String returns the schedule spec.
+func (s *Schedule) String() string {
+	return s.spec
+}
+
+// Next returns the first time matching the schedule after t, truncated to the
+// minute. It returns a zero time if nothing matches within the next years, eg:
+// on February 30th.
+func (s *Schedule) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.Year() + maxYears
+
+	for t.Year() <= limit {
+		if !has(s.month, int(t.Month())) {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+
+		if !s.matchDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+
+		if !has(s.hour, t.Hour()) {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+
+		if !has(s.minute, t.Minute()) {
+			t = t.Add(time.Minute)
+			continue
+		}
+
+		return t
+	}
+
+	return time.Time{}
+}
+
+func (s *Schedule) matchDay(t time.Time) bool {
+	dom := has(s.dom, t.Day())
+	dow := has(s.dow, int(t.Weekday()))
+
+	switch {
+	case s.domStar && s.dowStar:
+		return true
+	case s.domStar:
+		return dow
+	case s.dowStar:
+		return dom
+	default:
+		return dom || dow
+	}
+}
+
+func has(set uint64, v int) bool {
+	return set&(1<<uint(v)) != 0
+}
+
+// parseField parses a comma separated list of values, ranges and steps.
+func parseField(s string, f field) (uint64, error) {
+	var set uint64
+	for _, part := range strings.Split(s, ",") {
+		step, stepped := 1, false
+		if i := strings.IndexByte(part, '/'); i >= 0 {
+			n, err := strconv.Atoi(part[i+1:])
+			if err != nil || n < 1 {
+				return 0, fmt.Errorf("invalid %s step %q", f.name, part)
+			}
+			step, stepped = n, true
+			part = part[:i]
+		}
+
+		low, high := f.min, f.max
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+
+			var err error
+			low, err = strconv.Atoi(bounds[0])
+			if err != nil {
+				return 0, fmt.Errorf("invalid %s %q", f.name, part)
+			}
+
+			// a single value with a step starts there and goes up to the
+			// maximum, eg: 10/15 is 10,25,40,55.
+			high = low
+			if stepped {
+				high = f.max
+			}
+			if len(bounds) == 2 {
+				high, err = strconv.Atoi(bounds[1])
+				if err != nil {
+					return 0, fmt.Errorf("invalid %s %q", f.name, part)
+				}
+			}
+		}
+
+		if low < f.min || high > f.max || low > high {
+			return 0, fmt.Errorf("%s %q out of range %d-%d", f.name, part, f.min, f.max)
+		}
+
+		for v := low; v <= high; v += step {
+			set |= 1 << uint(v)
+		}
+	}
+
+	return set, nil
+}
@@ -0,0 +1,108 @@
+package cron
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseField(t *testing.T) {
+	minute := fields[0]
+	dow := fields[4]
+
+	tests := []struct {
+		spec string
+		f    field
+		want []int
+	}{
+		{"*", dow, []int{0, 1, 2, 3, 4, 5, 6, 7}},
+		{"5", minute, []int{5}},
+		{"1,15", minute, []int{1, 15}},
+		{"5-10", minute, []int{5, 6, 7, 8, 9, 10}},
+		{"0-30/10", minute, []int{0, 10, 20, 30}},
+		{"*/20", minute, []int{0, 20, 40}},
+		{"10/15", minute, []int{10, 25, 40, 55}},
+		{"1-5,0", dow, []int{0, 1, 2, 3, 4, 5}},
+		{"5/2", dow, []int{5, 7}},
+	}
+
+	for _, tt := range tests {
+		set, err := parseField(tt.spec, tt.f)
+		if err != nil {
+			t.Errorf("parseField(%q): unexpected error: %s", tt.spec, err)
+			continue
+		}
+
+		var got []int
+		for v := tt.f.min; v <= tt.f.max; v++ {
+			if has(set, v) {
+				got = append(got, v)
+			}
+		}
+
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseField(%q) = %v, want %v", tt.spec, got, tt.want)
+		}
+	}
+}
+
+func TestParseFieldErrors(t *testing.T) {
+	minute := fields[0]
+
+	for _, spec := range []string{"", "60", "-1", "a", "10-5", "5-60", "*/0", "*/a", "1-", "5/"} {
+		if _, err := parseField(spec, minute); err == nil {
+			t.Errorf("parseField(%q): expected an error", spec)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, spec := range []string{"", "* * * *", "* * * * * *", "@every", "0 24 * * *", "0 0 0 * *", "0 0 * 13 *", "0 0 * * 8"} {
+		if _, err := Parse(spec); err == nil {
+			t.Errorf("Parse(%q): expected an error", spec)
+		}
+	}
+}
+
+func TestNext(t *testing.T) {
+	// thursday.
+	from := time.Date(2026, 10, 1, 12, 30, 45, 0, time.UTC)
+
+	tests := []struct {
+		spec string
+		want time.Time
+	}{
+		{"* * * * *", time.Date(2026, 10, 1, 12, 31, 0, 0, time.UTC)},
+		{"30 12 * * *", time.Date(2026, 10, 2, 12, 30, 0, 0, time.UTC)},
+		{"0 3 * * *", time.Date(2026, 10, 2, 3, 0, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2026, 10, 1, 12, 45, 0, 0, time.UTC)},
+		{"10/20 * * * *", time.Date(2026, 10, 1, 12, 50, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2026, 10, 1, 13, 0, 0, 0, time.UTC)},
+		{"@daily", time.Date(2026, 10, 2, 0, 0, 0, 0, time.UTC)},
+		{"@weekly", time.Date(2026, 10, 4, 0, 0, 0, 0, time.UTC)},
+		{"@monthly", time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC)},
+		{"@yearly", time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC)},
+		// 7 is also sunday.
+		{"0 0 * * 7", time.Date(2026, 10, 4, 0, 0, 0, 0, time.UTC)},
+		// both days restricted: either the 15th or a monday.
+		{"0 0 15 * 1", time.Date(2026, 10, 5, 0, 0, 0, 0, time.UTC)},
+		// a day of month with a star step is unrestricted: only mondays.
+		{"0 0 */2 * 1", time.Date(2026, 10, 5, 0, 0, 0, 0, time.UTC)},
+		// a day of week with a star step is unrestricted: only the 15th.
+		{"0 0 15 * */2", time.Date(2026, 10, 15, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"0 0 30 2 *", time.Time{}},
+	}
+
+	for _, tt := range tests {
+		s, err := Parse(tt.spec)
+		if err != nil {
+			t.Errorf("Parse(%q): unexpected error: %s", tt.spec, err)
+			continue
+		}
+
+		if got := s.Next(from); !got.Equal(tt.want) {
+			t.Errorf("Parse(%q).Next(%s) = %s, want %s", tt.spec, from, got, tt.want)
+		}
+	}
+}
@@ -8,6 +8,7 @@ import (
 
 	"github.com/WiseGrowth/go-wisebot/logger"
 	"github.com/WiseGrowth/wisebot-operator/git"
+	"github.com/WiseGrowth/wisebot-operator/procstat"
 	"github.com/WiseGrowth/wisebot-operator/systemd"
 	"github.com/sirupsen/logrus"
 )
@@ -27,6 +28,7 @@ const (
 // errors
 var (
 	ErrSystemdServiceNotExists = errors.New("systemd service doesn't exists")
+	ErrNoRepository            = errors.New("daemon has no code repository")
 )
 
 // Daemon encapsulates a command an its repository
@@ -37,19 +39,33 @@ type Daemon interface {
 	Stop() error
 	Status() (Status, error)
 	// Update updates daemon codebase and returns a boolean indicating if there is
-	// new code or not.
-	Update() (bool, error)
+	// new code or not. The trigger is recorded in the deployment history.
+	Update(trigger string) (bool, error)
+	// UpdateFromFile updates the daemon codebase from a git bundle or an
+	// archive, without network access, like Update.
+	UpdateFromFile(path, checksum, trigger string) (bool, error)
+	// Rollback checks out an earlier revision of the daemon codebase, the
+	// previous deployment if the sha is empty.
+	Rollback(sha, trigger string) error
+	// Deployments returns the deployment history of the daemon codebase.
+	Deployments() ([]git.Deployment, error)
 	// Bootstrap pulls the daemon codebase if does not exists. If the codebase
 	// exists, depending on the given update parameter, updates the codebase.
 	Bootstrap(update bool) error
 	// Logger returns an initialized logger that contains daemon specific info.
 	Logger() *logrus.Entry
+	// MainPID returns the pid of the daemon main process, or 0 if it's not
+	// running.
+	MainPID() (int, error)
+	// Metrics returns the last resource usage samples of the daemon.
+	Metrics() *procstat.History
 }
 
 // daemon encapsulates a command an its repository
 type daemon struct {
-	name string
-	cu   codebaseUpdater
+	name    string
+	cu      codebaseUpdater
+	metrics *procstat.History
 
 	mu       sync.RWMutex
 	updating bool
@@ -58,18 +74,29 @@ type daemon struct {
 type codebaseUpdater interface {
 	Bootstrap(bool) error
 	CurrentHead() string
-	Update() (string, error)
+	CurrentRef() git.RefState
+	UpdateBy(trigger string) (string, error)
+	UpdateFromFile(path, checksum, trigger string) (string, error)
+	Rollback(sha, trigger string) (string, error)
+	Deployments() ([]git.Deployment, error)
 }
 
 // NewDaemon initializes a a daemon but it returns an error if the
-// systemd.Service does not exists.
+// systemd.Service does not exists. The repo can be nil for daemons that don't
+// have a code's repository, eg: filebeat.
 func NewDaemon(name string, r *git.Repo) (Daemon, error) {
 	exists := systemd.Exists(name)
 	if !exists {
 		return nil, ErrSystemdServiceNotExists
 	}
 
-	return &daemon{name: name, cu: r}, nil
+	d := &daemon{name: name, metrics: procstat.NewHistory(procstat.DefaultHistorySize)}
+	// r is only assigned when not nil, otherwise d.cu would hold a typed nil.
+	if r != nil {
+		d.cu = r
+	}
+
+	return d, nil
 }
 
 // MarshalJSON implements json marshal interface
@@ -80,13 +107,17 @@ func (d *daemon) MarshalJSON() (bytes []byte, err error) {
 	}
 
 	return json.Marshal(struct {
-		Name        string `json:"name"`
-		Status      Status `json:"status"`
-		RepoVersion string `json:"repo_version"`
+		Name        string           `json:"name"`
+		Status      Status           `json:"status"`
+		RepoVersion string           `json:"repo_version"`
+		RepoRef     *git.RefState    `json:"repo_ref,omitempty"`
+		Metrics     *procstat.Sample `json:"metrics,omitempty"`
 	}{
 		Name:        d.name,
 		Status:      status,
-		RepoVersion: d.cu.CurrentHead(),
+		RepoVersion: d.repoVersion(),
+		RepoRef:     d.repoRef(),
+		Metrics:     d.metrics.Last(),
 	})
 }
 
@@ -109,24 +140,42 @@ func (d *daemon) Stop() error {
 	return systemd.Stop(d.name)
 }
 
-// Update calls Daemon updater Update function if exists.
-func (d *daemon) Update() (updated bool, err error) {
+// MainPID asks systemd for the daemon main process pid.
+func (d *daemon) MainPID() (int, error) {
+	return systemd.MainPID(d.name)
+}
+
+// Metrics returns the last resource usage samples of the daemon.
+func (d *daemon) Metrics() *procstat.History {
+	return d.metrics
+}
+
+// Update calls Daemon updater Update function if exists. If the daemon has no
+// code's repository, it returns ErrNoRepository.
+func (d *daemon) Update(trigger string) (updated bool, err error) {
+	return d.update(func() (string, error) {
+		return d.cu.UpdateBy(trigger)
+	})
+}
+
+// UpdateFromFile calls Daemon updater UpdateFromFile function if exists. If the
+// daemon has no code's repository, it returns ErrNoRepository.
+func (d *daemon) UpdateFromFile(path, checksum, trigger string) (updated bool, err error) {
+	return d.update(func() (string, error) {
+		return d.cu.UpdateFromFile(path, checksum, trigger)
+	})
+}
+
+func (d *daemon) update(update func() (string, error)) (bool, error) {
 	if d.cu == nil {
-		return true, nil
+		return false, ErrNoRepository
 	}
 
-	defer func() {
-		d.mu.Lock()
-		d.updating = false
-		d.mu.Unlock()
-	}()
-
-	d.mu.Lock()
-	d.updating = true
-	d.mu.Unlock()
+	defer d.setUpdating(false)
+	d.setUpdating(true)
 
 	oldSha := d.cu.CurrentHead()
-	newSha, err := d.cu.Update()
+	newSha, err := update()
 	if err != nil {
 		return false, err
 	}
@@ -134,6 +183,36 @@ func (d *daemon) Update() (updated bool, err error) {
 	return oldSha != newSha, nil
 }
 
+// Rollback calls Daemon updater Rollback function if exists. If the daemon has
+// no code's repository, it returns ErrNoRepository.
+func (d *daemon) Rollback(sha, trigger string) error {
+	if d.cu == nil {
+		return ErrNoRepository
+	}
+
+	defer d.setUpdating(false)
+	d.setUpdating(true)
+
+	_, err := d.cu.Rollback(sha, trigger)
+	return err
+}
+
+// Deployments returns the deployment history of the daemon codebase. If the
+// daemon has no code's repository, it returns ErrNoRepository.
+func (d *daemon) Deployments() ([]git.Deployment, error) {
+	if d.cu == nil {
+		return nil, ErrNoRepository
+	}
+
+	return d.cu.Deployments()
+}
+
+func (d *daemon) setUpdating(updating bool) {
+	d.mu.Lock()
+	d.updating = updating
+	d.mu.Unlock()
+}
+
 func (d *daemon) Status() (Status, error) {
 	d.mu.RLock()
 	defer d.mu.RUnlock()
@@ -179,6 +258,27 @@ func (d *daemon) Logger() *logrus.Entry {
 	return logger.GetLogger().WithFields(logrus.Fields{
 		"name":         d.name,
 		"status":       status,
-		"repo_version": d.cu.CurrentHead(),
+		"repo_version": d.repoVersion(),
 	})
 }
+
+// repoVersion returns the repo current head, or an empty string if the daemon
+// has no code's repository.
+func (d *daemon) repoVersion() string {
+	if d.cu == nil {
+		return ""
+	}
+
+	return d.cu.CurrentHead()
+}
+
+// repoRef returns the ref the repo tracks, or nil if the daemon has no code's
+// repository.
+func (d *daemon) repoRef() *git.RefState {
+	if d.cu == nil {
+		return nil
+	}
+
+	ref := d.cu.CurrentRef()
+	return &ref
+}
@@ -4,6 +4,9 @@ import (
 	"encoding/json"
 	"fmt"
 	"sync"
+
+	"github.com/WiseGrowth/wisebot-operator/git"
+	"github.com/WiseGrowth/wisebot-operator/procstat"
 )
 
 // Store represents a set of daemons.
@@ -39,23 +42,48 @@ func (s *Store) Find(name string) (daemon Daemon, ok bool) {
 	return daemon, ok
 }
 
-// Bootstrap loops each service in the list and calls the bootstrap function.
-func (s *Store) Bootstrap(update bool) error {
+// List returns the daemons in the store.
+func (s *Store) List() []Daemon {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	for _, daemon := range s.list {
-		if err := daemon.Bootstrap(update); err != nil {
-			return err
-		}
+	daemons := make([]Daemon, 0, len(s.list))
+	for _, d := range s.list {
+		daemons = append(daemons, d)
 	}
 
-	return nil
+	return daemons
+}
+
+// Metrics returns the last resource usage samples of a specific daemon. If the
+// daemon is not found in the list, it returns an error.
+func (s *Store) Metrics(name string) (*procstat.History, error) {
+	d, ok := s.Find(name)
+	if !ok {
+		return nil, fmt.Errorf("daemons: daemon %q not found", name)
+	}
+
+	return d.Metrics(), nil
 }
 
 // Update search the given command in the map and runs its Update function. If
 // the command is not found, an error is returned.
-func (s *Store) Update(name string) error {
+func (s *Store) Update(name, trigger string) error {
+	return s.update(name, func(d Daemon) (bool, error) {
+		return d.Update(trigger)
+	})
+}
+
+// UpdateFromFile updates a specific daemon codebase from a git bundle or an
+// archive, and restarts the daemon like Update. If the daemon is not found in
+// the list, it returns an error.
+func (s *Store) UpdateFromFile(name, path, checksum, trigger string) error {
+	return s.update(name, func(d Daemon) (bool, error) {
+		return d.UpdateFromFile(path, checksum, trigger)
+	})
+}
+
+func (s *Store) update(name string, update func(Daemon) (bool, error)) error {
 	daemon, ok := s.Find(name)
 
 	if !ok {
@@ -63,7 +91,11 @@ func (s *Store) Update(name string) error {
 	}
 
 	daemon.Logger().Info("Running update")
-	updated, err := daemon.Update()
+	updated, err := update(daemon)
+	if err == ErrNoRepository {
+		daemon.Logger().Info("No code repository, skipping update")
+		return nil
+	}
 	if err != nil {
 		return err
 	}
@@ -77,6 +109,35 @@ func (s *Store) Update(name string) error {
 	return daemon.Restart()
 }
 
+// Rollback checks out an earlier revision of a specific daemon codebase, the
+// previous deployment if the sha is empty, and restarts the daemon. If the
+// daemon is not found in the list, it returns an error.
+func (s *Store) Rollback(name, sha, trigger string) error {
+	d, ok := s.Find(name)
+	if !ok {
+		return fmt.Errorf("daemons: daemon %q not found for rolling back", name)
+	}
+
+	d.Logger().WithField("version", sha).Info("Running rollback")
+	if err := d.Rollback(sha, trigger); err != nil {
+		return err
+	}
+
+	d.Logger().Info("Rollback applied, restarting daemon")
+	return d.Restart()
+}
+
+// Deployments returns the deployment history of a specific daemon codebase. If
+// the daemon is not found in the list, it returns an error.
+func (s *Store) Deployments(name string) ([]git.Deployment, error) {
+	d, ok := s.Find(name)
+	if !ok {
+		return nil, fmt.Errorf("daemons: daemon %q not found", name)
+	}
+
+	return d.Deployments()
+}
+
 // Save initialize the list and add the daemon to it.
 func (s *Store) Save(d Daemon) Daemon {
 	s.mu.RLock()
@@ -93,6 +154,14 @@ func (s *Store) Save(d Daemon) Daemon {
 	return d
 }
 
+// Remove deletes the daemon from the list.
+func (s *Store) Remove(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.list, name)
+}
+
 // StartDaemon starts a specific daemon inside the store.
 // If the daemon is not found in the list, it returns an error.
 func (s *Store) StartDaemon(name string) error {
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"time"
+
+	"github.com/WiseGrowth/wisebot-operator/command"
+	"github.com/WiseGrowth/wisebot-operator/daemon"
+	"github.com/WiseGrowth/wisebot-operator/manifest"
+)
+
+const (
+	// requiredUnitTimeout is how long a service waits for its required units
+	// to be up before being blocked.
+	requiredUnitTimeout = 30 * time.Second
+
+	requiredUnitPollInterval = 1 * time.Second
+)
+
+// Bootstrap bootstraps the units of the given kind in dependency order, so
+// units sharing a repo always bootstrap it the same way.
+func (ul *UnitLoader) Bootstrap(kind manifest.Kind, update bool) error {
+	ul.Lock()
+	defer ul.Unlock()
+
+	for _, u := range ul.order {
+		if u.Kind != kind {
+			continue
+		}
+
+		var err error
+		switch kind {
+		case manifest.KindService:
+			if svc, ok := ul.Services.Find(u.Name); ok {
+				err = svc.Bootstrap(update)
+			}
+		case manifest.KindDaemon:
+			if d, ok := ul.Daemons.Find(u.Name); ok {
+				err = d.Bootstrap(update)
+			}
+		case manifest.KindJob:
+			if job, ok := ul.Jobs.Find(u.Name); ok {
+				err = job.Bootstrap(update)
+			}
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Start starts the services in dependency order, so every service is started
+// after the units it depends on. Services whose required units are not up are
+// blocked instead of started. Then, the jobs are scheduled and the ones that
+// run on boot are run. Nothing is started once the units were stopped.
+func (ul *UnitLoader) Start() error {
+	ul.Lock()
+	defer ul.Unlock()
+
+	// the lock is released while a service waits for its required units, so
+	// the order is copied in case it's reloaded meanwhile.
+	order := ul.order
+	for _, u := range order {
+		if u.Kind != manifest.KindService {
+			continue
+		}
+
+		if err := ul.startService(u); err != nil {
+			return err
+		}
+	}
+
+	if !ul.stopped {
+		ul.Jobs.Start()
+	}
+
+	return nil
+}
+
+// Stop stops the jobs and then the services in reverse dependency order, so
+// every service is stopped before the units it depends on. The units can't
+// be started again afterwards.
+func (ul *UnitLoader) Stop() error {
+	ul.Lock()
+	defer ul.Unlock()
+
+	ul.stopped = true
+	ul.Jobs.Stop()
+
+	for i := len(ul.order) - 1; i >= 0; i-- {
+		u := ul.order[i]
+		if u.Kind != manifest.KindService {
+			continue
+		}
+
+		svc, ok := ul.Services.Find(u.Name)
+		if !ok {
+			continue
+		}
+
+		svc.logger().Info("Stopping")
+		if err := svc.Stop(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// startService waits for the service required units and starts it. If any
+// of them is not up, the service is blocked. It must be called with the loader
+// locked, but the lock is released while waiting, so the loader is not held
+// for up to requiredUnitTimeout per required unit. The service is not started
+// if the units were stopped, or the service removed, meanwhile.
+func (ul *UnitLoader) startService(u manifest.Unit) error {
+	if ul.stopped {
+		return nil
+	}
+
+	svc, ok := ul.Services.Find(u.Name)
+	if !ok {
+		return fmt.Errorf("units: service %q not found for starting", u.Name)
+	}
+
+	if len(u.Requires) > 0 {
+		m := ul.manifest
+		ul.Unlock()
+		blockedBy, err := ul.waitUnits(m, u.Requires)
+		ul.Lock()
+
+		if current, ok := ul.Services.Find(u.Name); ul.stopped || !ok || current != svc {
+			svc.logger().Info("Units changed while waiting for the required units, not starting")
+			return nil
+		}
+
+		if err != nil {
+			svc.logger().WithField("required_unit", blockedBy).Warn(err)
+			svc.block(blockedBy)
+			return nil
+		}
+	}
+
+	svc.logger().Info("Starting")
+	return svc.Start()
+}
+
+// waitUnits waits until every unit is up, in order. If one of them is not, it
+// returns its name and the error.
+func (ul *UnitLoader) waitUnits(m *manifest.Manifest, names []string) (string, error) {
+	for _, name := range names {
+		if err := ul.waitUnit(m, name); err != nil {
+			return name, err
+		}
+	}
+
+	return "", nil
+}
+
+// waitUnit waits until the unit is up. It returns an error if the unit is not
+// up within requiredUnitTimeout, or if it's a service that won't run without
+// someone starting it. The unit is looked up in the given manifest, since the
+// loader is not locked.
+func (ul *UnitLoader) waitUnit(m *manifest.Manifest, name string) error {
+	deadline := time.Now().Add(requiredUnitTimeout)
+	for {
+		up, err := ul.unitUp(m, name)
+		if err != nil {
+			return err
+		}
+
+		if up {
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("units: required unit %q is not up after %s", name, requiredUnitTimeout)
+		}
+
+		time.Sleep(requiredUnitPollInterval)
+	}
+}
+
+// unitUp returns true if the unit is running. Services with a readiness probe
+// must also be ready.
+func (ul *UnitLoader) unitUp(m *manifest.Manifest, name string) (bool, error) {
+	u, ok := m.Find(name)
+	if !ok {
+		return false, fmt.Errorf("units: required unit %q not found", name)
+	}
+
+	switch u.Kind {
+	case manifest.KindService:
+		svc, ok := ul.Services.Find(name)
+		if !ok {
+			return false, fmt.Errorf("units: required service %q not found", name)
+		}
+
+		switch status := svc.command().Status(); status {
+		case command.StatusRunning:
+			return svc.ready(), nil
+		case command.StatusBootingError, command.StatusCrashLoop, command.StatusBlocked, command.StatusStopped:
+			return false, fmt.Errorf("units: required service %q is %s", name, status)
+		}
+	case manifest.KindDaemon:
+		// daemons are skipped on darwin since there is no systemd.
+		if runtime.GOOS == "darwin" {
+			return true, nil
+		}
+
+		d, ok := ul.Daemons.Find(name)
+		if !ok {
+			return false, fmt.Errorf("units: required daemon %q not found", name)
+		}
+
+		status, err := d.Status()
+		return err == nil && status == daemon.StatusRunning, nil
+	}
+
+	return false, nil
+}
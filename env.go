@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/WiseGrowth/wisebot-operator/envfile"
+	"github.com/WiseGrowth/wisebot-operator/manifest"
+)
+
+// redactedValue replaces the secret values in the service json.
+const redactedValue = "********"
+
+// secretKeywords are the variable name parts that mark a variable as secret.
+var secretKeywords = []string{"SECRET", "PASSWORD", "PASSWD", "TOKEN", "KEY", "CREDENTIAL", "PRIVATE", "DSN"}
+
+// serviceEnv builds the service environment. The env files are read every
+// time the service starts, so changes in them are applied by restarting it.
+type serviceEnv struct {
+	clean bool
+	user  *user.User
+	files []string // expanded paths
+	vars  map[string]string
+
+	mu     sync.RWMutex // guards merged
+	merged map[string]string
+}
+
+// newUnitEnv returns the service environment declared in the manifest. It
+// returns nil if the service inherits the operator environment as is.
+func newUnitEnv(u manifest.Unit, usr *user.User) (*serviceEnv, error) {
+	if !u.CleanEnv && usr == nil && len(u.EnvFiles) == 0 && len(u.Env) == 0 {
+		return nil, nil
+	}
+
+	e := &serviceEnv{clean: u.CleanEnv, user: usr, vars: u.Env}
+	for _, f := range u.EnvFiles {
+		path, err := expandHome(f)
+		if err != nil {
+			return nil, err
+		}
+		e.files = append(e.files, path)
+	}
+
+	return e, nil
+}
+
+// resolve merges the service environment, in order: the operator environment
+// unless it is clean, the variables that describe the service user, the env
+// files and the env variables.
+func (e *serviceEnv) resolve() ([]string, error) {
+	merged := make(map[string]string)
+
+	if e.user != nil {
+		merged["HOME"] = e.user.HomeDir
+		merged["USER"] = e.user.Username
+		merged["LOGNAME"] = e.user.Username
+	}
+
+	for _, path := range e.files {
+		vars, err := envfile.Read(path)
+		if err != nil {
+			return nil, err
+		}
+
+		for k, v := range vars {
+			merged[k] = v
+		}
+	}
+
+	for k, v := range e.vars {
+		merged[k] = v
+	}
+
+	e.mu.Lock()
+	e.merged = merged
+	e.mu.Unlock()
+
+	env := make([]string, 0)
+	if !e.clean {
+		env = append(env, os.Environ()...)
+	}
+
+	keys := make([]string, 0, len(merged))
+	for k := range merged {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	// exec.Cmd keeps the last value of duplicated variables, so the merged
+	// ones override the operator ones.
+	for _, k := range keys {
+		env = append(env, fmt.Sprintf("%s=%s", k, merged[k]))
+	}
+
+	return env, nil
+}
+
+// redacted returns the variables merged on the last resolve, without the
+// operator environment. Secret values are redacted.
+func (e *serviceEnv) redacted() map[string]string {
+	if e == nil {
+		return nil
+	}
+
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	vars := make(map[string]string, len(e.merged))
+	for k, v := range e.merged {
+		if isSecret(k) {
+			v = redactedValue
+		}
+		vars[k] = v
+	}
+
+	return vars
+}
+
+func isSecret(key string) bool {
+	upper := strings.ToUpper(key)
+	for _, keyword := range secretKeywords {
+		if strings.Contains(upper, keyword) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,107 @@
+package envfile
+
+/*
+This package reads dotenv files: one KEY=VALUE variable per line, optionally
+prefixed by `export`. Blank lines and lines starting with # are ignored.
+Values can be wrapped by double quotes, which support \n, \t, \" and \\
+escapes, or by single quotes, which are taken literally.
+*/
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Read parses the dotenv file located at the given path. If a variable is
+// declared more than once, the last value is kept.
+func Read(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	vars := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		key, value, err := parseLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("envfile: %s:%d: %s", path, n, err.Error())
+		}
+		vars[key] = value
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return vars, nil
+}
+
+func parseLine(line string) (key, value string, err error) {
+	line = strings.TrimPrefix(line, "export ")
+
+	i := strings.IndexByte(line, '=')
+	if i < 0 {
+		return "", "", fmt.Errorf("missing = in %q", line)
+	}
+
+	key = strings.TrimSpace(line[:i])
+	if len(key) == 0 || strings.ContainsAny(key, " \t") {
+		return "", "", fmt.Errorf("invalid variable name %q", key)
+	}
+
+	value, err = parseValue(strings.TrimSpace(line[i+1:]))
+	return key, value, err
+}
+
+func parseValue(v string) (string, error) {
+	if len(v) == 0 {
+		return "", nil
+	}
+
+	switch quote := v[0]; quote {
+	case '\'':
+		end := strings.IndexByte(v[1:], '\'')
+		if end < 0 {
+			return "", fmt.Errorf("unterminated quoted value %s", v)
+		}
+		return v[1 : end+1], nil
+	case '"':
+		var b strings.Builder
+		for i := 1; i < len(v); i++ {
+			c := v[i]
+			if c == '"' {
+				return b.String(), nil
+			}
+
+			if c == '\\' && i+1 < len(v) {
+				i++
+				switch v[i] {
+				case 'n':
+					c = '\n'
+				case 't':
+					c = '\t'
+				default:
+					c = v[i]
+				}
+			}
+			b.WriteByte(c)
+		}
+		return "", fmt.Errorf("unterminated quoted value %s", v)
+	}
+
+	// unquoted values end at an inline comment.
+	if i := strings.Index(v, " #"); i >= 0 {
+		v = strings.TrimSpace(v[:i])
+	}
+
+	return v, nil
+}
@@ -0,0 +1,94 @@
+package envfile
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseLine(t *testing.T) {
+	tests := []struct {
+		line       string
+		key, value string
+	}{
+		{"KEY=value", "KEY", "value"},
+		{"export KEY=value", "KEY", "value"},
+		{"KEY = value ", "KEY", "value"},
+		{"KEY=", "KEY", ""},
+		{"KEY=a=b", "KEY", "a=b"},
+		{"KEY=value # comment", "KEY", "value"},
+		{"KEY=value#not-a-comment", "KEY", "value#not-a-comment"},
+		{`KEY="a b"`, "KEY", "a b"},
+		{`KEY="a # b" # comment`, "KEY", "a # b"},
+		{`KEY="line\nnext\ttab \"quoted\" \\"`, "KEY", "line\nnext\ttab \"quoted\" \\"},
+		{`KEY='a\nb "c"'`, "KEY", `a\nb "c"`},
+	}
+
+	for _, tt := range tests {
+		key, value, err := parseLine(tt.line)
+		if err != nil {
+			t.Errorf("parseLine(%q): unexpected error: %s", tt.line, err)
+			continue
+		}
+
+		if key != tt.key || value != tt.value {
+			t.Errorf("parseLine(%q) = %q, %q, want %q, %q", tt.line, key, value, tt.key, tt.value)
+		}
+	}
+}
+
+func TestParseLineErrors(t *testing.T) {
+	for _, line := range []string{"KEY", "=value", "MY KEY=value", `KEY="value`, `KEY='value`} {
+		if _, _, err := parseLine(line); err == nil {
+			t.Errorf("parseLine(%q): expected an error", line)
+		}
+	}
+}
+
+func TestRead(t *testing.T) {
+	dir, err := ioutil.TempDir("", "envfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		contents string
+		want     map[string]string
+		err      bool
+	}{
+		{"", map[string]string{}, false},
+		{"# comment\n\nA=1\n  B=2  \n", map[string]string{"A": "1", "B": "2"}, false},
+		{"A=1\nA=2\n", map[string]string{"A": "2"}, false},
+		{"A=1\nB\n", nil, true},
+	}
+
+	for i, tt := range tests {
+		path := filepath.Join(dir, ".env")
+		if err := ioutil.WriteFile(path, []byte(tt.contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := Read(path)
+		if tt.err {
+			if err == nil {
+				t.Errorf("%d: Read: expected an error", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%d: Read: unexpected error: %s", i, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%d: Read = %v, want %v", i, got, tt.want)
+		}
+	}
+
+	if _, err := Read(filepath.Join(dir, "missing")); !os.IsNotExist(err) {
+		t.Errorf("Read of a missing file: got %v, want a not exist error", err)
+	}
+}
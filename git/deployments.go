@@ -0,0 +1,287 @@
+package git
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Deployment triggers
+const (
+	TriggerBootstrap = "bootstrap" // clone, or update when the operator starts
+	TriggerUpdate    = "update"
+	TriggerMQTT      = "mqtt"
+	TriggerHTTP      = "http"
+	TriggerProbation = "probation" // automatic rollback of a failed update
+	TriggerUSB       = "usb"       // offline update from a USB stick
+)
+
+// deploymentsFile is the deployment history file, inside the repo .git
+// directory so it's shared by the units that use the repo.
+const deploymentsFile = "wisebot-deployments.json"
+
+// maxDeployments is the number of deployments kept in the history.
+const maxDeployments = 50
+
+// Deployment represents a revision checked out in the repo working tree. A
+// discarded deployment was checked out into a release that was never
+// activated, since its hooks failed. File is the bundle or archive the
+// revision was deployed from, if it was updated offline. Verified deployments
+// were signed by one of the repo trusted keys.
+type Deployment struct {
+	SHA            string    `json:"sha"`
+	PreviousSHA    string    `json:"previous_sha,omitempty"`
+	Tag            string    `json:"tag,omitempty"`
+	Trigger        string    `json:"trigger"`
+	Rollback       bool      `json:"rollback"`
+	HooksSucceeded bool      `json:"hooks_succeeded"`
+	HooksError     string    `json:"hooks_error,omitempty"`
+	Discarded      bool      `json:"discarded,omitempty"`
+	File           string    `json:"file,omitempty"`
+	Verified       bool      `json:"verified,omitempty"`
+	DeployedAt     time.Time `json:"deployed_at"`
+}
+
+// Deployments returns the repo deployment history, oldest first.
+func (r *Repo) Deployments() ([]Deployment, error) {
+	b, err := ioutil.ReadFile(r.deploymentsPath())
+	if os.IsNotExist(err) {
+		return []Deployment{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var deployments []Deployment
+	if err := json.Unmarshal(b, &deployments); err != nil {
+		return nil, fmt.Errorf("git: invalid deployment history in %s: %s", r.Path, err)
+	}
+
+	return deployments, nil
+}
+
+// Rollback checks out an earlier revision, runs the post receive hooks and
+// returns the new head sha. The revision must be signed by a trusted key, if
+// the repo has trusted keys. If the sha is empty, it rolls back to the
+// revision deployed before the current one, skipping the revisions that were
+// rolled back. Updates move the repo forward again.
+func (r *Repo) Rollback(sha, trigger string) (string, error) {
+	log := r.logger()
+
+	deployments, err := r.Deployments()
+	if err != nil {
+		return "", err
+	}
+
+	if len(sha) == 0 {
+		sha = previousDeployment(deployments, r.head)
+		if len(sha) == 0 {
+			return "", fmt.Errorf("git: no previous deployment of %s", r.Path)
+		}
+	}
+
+	if !r.hasCommit(sha) {
+		return "", fmt.Errorf("git: commit %q not found in %s", sha, r.Path)
+	}
+
+	rev, err := r.revParse(sha)
+	if err != nil {
+		return "", err
+	}
+
+	if rev == r.head {
+		return "", fmt.Errorf("git: %s is already checked out in %s", rev, r.Path)
+	}
+
+	if err := r.verify(rev); err != nil {
+		return "", err
+	}
+
+	log = log.WithField("new_version", rev)
+	log.Info("Rolling back")
+	if err := r.checkout(rev); err != nil {
+		return "", err
+	}
+
+	// the tag is only known if the revision was deployed from one.
+	var tag string
+	for _, d := range deployments {
+		if d.SHA == rev {
+			tag = d.Tag
+		}
+	}
+
+	return r.deploy(tag, trigger, true)
+}
+
+// deploy runs the post receive hooks after a checkout, updates the head and
+// records the deployment. In place, the deployment is recorded even if the
+// hooks fail, since the revision is checked out anyway. Releases are only
+// activated if the hooks succeed, and discarded otherwise.
+func (r *Repo) deploy(tag, trigger string, rollback bool) (string, error) {
+	previous := r.head
+
+	sha, err := r.headAt(r.WorkTree())
+	if err != nil {
+		r.discardRelease()
+		return "", err
+	}
+
+	hooksErr := r.runPostReceiveHooks()
+
+	d := Deployment{
+		SHA:            sha,
+		PreviousSHA:    previous,
+		Tag:            tag,
+		Trigger:        trigger,
+		Rollback:       rollback,
+		File:           r.offlineFile,
+		Verified:       r.TrustedKeys != nil, // verified before the checkout
+		HooksSucceeded: hooksErr == nil,
+		DeployedAt:     time.Now(),
+	}
+	if hooksErr != nil {
+		d.HooksError = hooksErr.Error()
+	}
+
+	var activateErr error
+	if len(r.pending) > 0 {
+		if hooksErr == nil {
+			activateErr = r.activateRelease()
+		}
+		if hooksErr != nil || activateErr != nil {
+			r.logger().WithField("release", sha).Warn("Discarding release, keeping the current one")
+			r.discardRelease()
+			d.Discarded = true
+		}
+	}
+	if !d.Discarded {
+		r.tag = tag
+	}
+
+	if err := r.recordDeployment(d); err != nil {
+		r.logger().WithField("error", err).Warn("Could not record the deployment")
+	}
+
+	if err := r.updateHead(); err != nil {
+		return "", err
+	}
+
+	if hooksErr != nil {
+		r.logger().Debugf("Error when running hooks: %s\n", hooksErr.Error())
+		return "", hooksErr
+	}
+
+	if activateErr != nil {
+		return "", activateErr
+	}
+
+	return r.head, nil
+}
+
+// headVerified returns true if the last deployment of the current head was
+// verified against the repo trusted keys.
+func (r *Repo) headVerified() bool {
+	deployments, err := r.Deployments()
+	if err != nil {
+		return false
+	}
+
+	for i := len(deployments) - 1; i >= 0; i-- {
+		if d := deployments[i]; d.SHA == r.head && !d.Discarded {
+			return d.Verified
+		}
+	}
+
+	return false
+}
+
+// rolledBackFrom returns the revisions the current head was rolled back from,
+// following consecutive rollbacks, or nil if the head was not deployed by a
+// rollback.
+func (r *Repo) rolledBackFrom() map[string]bool {
+	deployments, err := r.Deployments()
+	if err != nil {
+		return nil
+	}
+
+	var from map[string]bool
+	for i := len(deployments) - 1; i >= 0; i-- {
+		d := deployments[i]
+		if d.Discarded {
+			continue
+		}
+		if !d.Rollback || (from == nil && d.SHA != r.head) {
+			break
+		}
+
+		if from == nil {
+			from = make(map[string]bool)
+		}
+		from[d.PreviousSHA] = true
+	}
+
+	return from
+}
+
+// lastDeployment returns the last recorded deployment, if any.
+func (r *Repo) lastDeployment() (Deployment, bool) {
+	deployments, err := r.Deployments()
+	if err != nil || len(deployments) == 0 {
+		return Deployment{}, false
+	}
+
+	return deployments[len(deployments)-1], true
+}
+
+// recordDeployment appends the deployment to the history file, dropping the
+// oldest ones. The file is replaced atomically.
+func (r *Repo) recordDeployment(d Deployment) error {
+	deployments, err := r.Deployments()
+	if err != nil {
+		deployments = nil
+	}
+
+	deployments = append(deployments, d)
+	if len(deployments) > maxDeployments {
+		deployments = deployments[len(deployments)-maxDeployments:]
+	}
+
+	b, err := json.MarshalIndent(deployments, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := r.deploymentsPath() + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, r.deploymentsPath())
+}
+
+func (r *Repo) deploymentsPath() string {
+	return filepath.Join(r.gitPath(), ".git", deploymentsFile)
+}
+
+// previousDeployment returns the sha deployed before the current head, or an
+// empty string if there is none. The revisions that were rolled back or
+// discarded are skipped, so rolling back twice goes further back instead of
+// returning to the broken revision.
+func previousDeployment(deployments []Deployment, head string) string {
+	skip := map[string]bool{head: true}
+	for i := len(deployments) - 1; i >= 0; i-- {
+		d := deployments[i]
+		if !skip[d.SHA] && !d.Discarded {
+			return d.SHA
+		}
+		if d.Rollback {
+			skip[d.PreviousSHA] = true
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,207 @@
+package git
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultCommandHookTimeout is how long a command hook can run if it has no
+// timeout. Builds on a raspberry can take a while.
+const DefaultCommandHookTimeout = 10 * time.Minute
+
+// CommandHook represents a post-receive hook that runs a command in the repo
+// work tree, eg: `make build`.
+type CommandHook struct {
+	Name string
+	Args []string
+	// Env is appended to the operator environment.
+	Env []string
+	// Dir is relative to the work tree, which is the default.
+	Dir     string
+	Timeout time.Duration
+	// Credential is the user and groups the command runs as. If nil, it runs
+	// as the repo user. If it's another user, the work tree is given to it
+	// before running the command, so it can write to it.
+	Credential *syscall.Credential
+	// Paths are path patterns, eg: src or package*.json. If set, the command
+	// only runs when the revision checked out changes a file matching one of
+	// them, or a directory that contains it. They are ignored when the work
+	// tree is a new release, since nothing was built in it yet.
+	Paths []string
+}
+
+// Slug combines the hook name and args in order to return a verbose
+// identifier.
+func (h CommandHook) Slug() string {
+	return strings.TrimSpace(fmt.Sprintf("%s %s", h.Name, strings.Join(h.Args, " ")))
+}
+
+// PostReceiveHook returns the function that runs the command hook.
+func (h CommandHook) PostReceiveHook() PostReceiveHook {
+	return h.run
+}
+
+func (h CommandHook) run(r *Repo) error {
+	log := r.logger().WithField("hook", h.Slug())
+
+	// a new release is a fresh checkout, only in-place or reused work trees
+	// keep what the previous hooks built.
+	if len(h.Paths) > 0 && !r.newWorkTree() {
+		changed, err := r.changedFiles()
+		if err != nil {
+			return err
+		}
+
+		if changed != nil && !matchPaths(h.Paths, changed) {
+			log.Info("No matching changes, skipping hook")
+			return nil
+		}
+	}
+
+	credential := h.Credential
+	if credential == nil {
+		credential = r.Credential
+	}
+
+	if !r.ownedByRepoUser(credential) {
+		uid, gid := int(credential.Uid), int(credential.Gid)
+		if err := chownWorkTree(r.WorkTree(), uid, gid); err != nil {
+			return fmt.Errorf("git: could not give the work tree to the post-receive hook %q user: %s", h.Slug(), err.Error())
+		}
+	}
+
+	timeout := h.Timeout
+	if timeout <= 0 {
+		timeout = DefaultCommandHookTimeout
+	}
+
+	var out bytes.Buffer
+	cmd := r.command(filepath.Join(r.WorkTree(), h.Dir), h.Name, h.Args...)
+	cmd.Env = r.env(h.Env...)
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	// its own process group, so the timeout also kills the command children.
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true, Credential: credential}
+
+	log.Info("Running post-receive hook")
+	err := cmd.Start()
+	if err == nil {
+		timer := time.AfterFunc(timeout, func() {
+			syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		})
+		err = cmd.Wait()
+		if !timer.Stop() {
+			err = fmt.Errorf("timed out after %s", timeout)
+		}
+	}
+
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"output": out.String(),
+			"err":    err.Error(),
+		}).Debug("Error when running post-receive hook")
+
+		reason := err.Error()
+		if line := lastLine(out.Bytes(), err); line != reason {
+			reason += ": " + line
+		}
+
+		return fmt.Errorf("git: post-receive hook %q failed: %s", h.Slug(), reason)
+	}
+
+	return nil
+}
+
+// chownWorkTree gives the work tree files to the user, since the repo is cloned
+// by the repo user. The work tree directory itself keeps its owner, since git
+// refuses to work in directories owned by other users, but it becomes writable
+// by the user group. The git directory is left untouched.
+func chownWorkTree(dir string, uid, gid int) error {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Chown(dir, -1, gid); err != nil {
+		return err
+	}
+
+	if err := os.Chmod(dir, info.Mode().Perm()|0070); err != nil {
+		return err
+	}
+
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if path == dir {
+			return nil
+		}
+
+		if info.Name() == ".git" && filepath.Dir(path) == dir {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if st, ok := info.Sys().(*syscall.Stat_t); ok && int(st.Uid) == uid && int(st.Gid) == gid {
+			return nil
+		}
+
+		return os.Lchown(path, uid, gid)
+	})
+}
+
+// changedFiles returns the files changed between the current head and the
+// revision being deployed. It returns nil if there is no current head, since
+// everything changed.
+func (r *Repo) changedFiles() ([]string, error) {
+	if len(r.head) == 0 {
+		return nil, nil
+	}
+
+	sha, err := r.headAt(r.WorkTree())
+	if err != nil {
+		return nil, err
+	}
+
+	diff, err := r.git(nil, "diff", "--name-only", "-z", r.head, sha)
+	if err != nil {
+		return nil, err
+	}
+
+	changed := []string{}
+	for _, f := range strings.Split(diff, "\x00") {
+		if len(f) > 0 {
+			changed = append(changed, f)
+		}
+	}
+
+	return changed, nil
+}
+
+// matchPaths returns true if any of the files, or the directories that
+// contain them, match any of the patterns.
+func matchPaths(patterns, files []string) bool {
+	for _, f := range files {
+		for p := f; p != "." && p != "/"; p = path.Dir(p) {
+			for _, pattern := range patterns {
+				if ok, _ := path.Match(strings.TrimSuffix(pattern, "/"), p); ok {
+					return true
+				}
+			}
+		}
+	}
+
+	return false
+}
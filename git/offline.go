@@ -0,0 +1,387 @@
+package git
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// bundleSignatures are the first bytes of the git bundle formats.
+var bundleSignatures = [][]byte{[]byte("# v2 git bundle\n"), []byte("# v3 git bundle\n")}
+
+// archiveCommitter is the author and committer of the commits created from
+// archives.
+var archiveCommitter = []string{
+	"GIT_AUTHOR_NAME=wisebot-operator",
+	"GIT_AUTHOR_EMAIL=operator@wisebot",
+	"GIT_COMMITTER_NAME=wisebot-operator",
+	"GIT_COMMITTER_EMAIL=operator@wisebot",
+}
+
+// UpdateFromFile updates the repo without network access, from a git bundle
+// or from a tar archive of the sources, optionally gzipped. The format is
+// detected from the file contents. The checksum is the hex sha256 of the file,
+// it's required for archives since they carry no integrity check of their
+// own. It returns the new head sha, or the current one if the file brings no
+// updates.
+//
+// Bundles must be built on the repo history, and they are fetched as if they
+// came from the remote, so branches only move forward and the revision the ref
+// resolves to is checked out like in Update. Archives become a commit on top
+// of the current head, so they can't be verified against trusted keys and are
+// refused by repos that have them. The next online update checks out the
+// remote revision again.
+//
+// Files uploaded through http can come from anyone that reaches the device, so
+// they are refused unless the repo has trusted keys, which means only signed
+// bundles are accepted.
+func (r *Repo) UpdateFromFile(path, checksum, trigger string) (string, error) {
+	if trigger == TriggerHTTP && r.TrustedKeys == nil {
+		return "", ErrUntrustedUpload
+	}
+
+	r.logger().WithField("file", path).Info("Updating from file")
+	r.offlineFile = filepath.Base(path)
+	defer func() { r.offlineFile = "" }()
+
+	if len(checksum) > 0 {
+		if err := verifyChecksum(path, checksum); err != nil {
+			return "", err
+		}
+	}
+
+	bundle, err := isBundle(path)
+	if err != nil {
+		return "", err
+	}
+
+	if bundle {
+		return r.updateFromBundle(path, trigger)
+	}
+
+	if len(checksum) == 0 {
+		return "", fmt.Errorf("git: archive %s has no checksum", path)
+	}
+
+	return r.updateFromArchive(path, checksum, trigger)
+}
+
+func (r *Repo) updateFromBundle(path, trigger string) (string, error) {
+	// git runs as the repo user, which may not be able to read the file, eg:
+	// when it was uploaded into a private temporary directory.
+	if r.Credential != nil {
+		tmp, err := ioutil.TempDir("", "wisebot-bundle")
+		if err != nil {
+			return "", err
+		}
+		defer os.RemoveAll(tmp)
+
+		bundle := filepath.Join(tmp, filepath.Base(path))
+		if err := copyFile(path, bundle); err != nil {
+			return "", err
+		}
+
+		if err := r.chownAll(tmp); err != nil {
+			return "", err
+		}
+		path = bundle
+	}
+
+	verify := r.command(r.gitPath(), "git", "bundle", "verify", path)
+	if out, err := verify.CombinedOutput(); err != nil {
+		// the missing prerequisite commits are listed one per line.
+		reason := strings.Replace(sanitizeOutput(out), "\n", " ", -1)
+		return "", fmt.Errorf("git: bundle %s does not apply to %s: %s", path, r.Path, reason)
+	}
+
+	// refspecs are not forced, so the fetch is refused if a branch would not
+	// fast-forward or a tag would change.
+	fetch := r.command(r.gitPath(), "git", "fetch", path,
+		"refs/heads/*:refs/remotes/"+upstreamBase+"/*",
+		"refs/tags/*:refs/tags/*",
+	)
+	if out, err := fetch.CombinedOutput(); err != nil {
+		return "", fmt.Errorf("git: could not fetch bundle %s: %s", path, lastLine(out, err))
+	}
+
+	return r.sync(trigger)
+}
+
+func (r *Repo) updateFromArchive(path, checksum, trigger string) (string, error) {
+	if r.TrustedKeys != nil {
+		return "", fmt.Errorf("git: archive %s is not signed, %s only deploys signed revisions", path, r.Path)
+	}
+
+	tmp, err := ioutil.TempDir("", "wisebot-archive")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(tmp)
+
+	tree := filepath.Join(tmp, "tree")
+	if err := extractArchive(path, tree); err != nil {
+		return "", err
+	}
+
+	// git runs as the repo user, which reads the tree and writes the index.
+	if err := r.chownAll(tmp); err != nil {
+		return "", err
+	}
+
+	// archives with a single top level directory, like the GitHub ones, are
+	// rooted at it.
+	if infos, err := ioutil.ReadDir(tree); err == nil && len(infos) == 1 && infos[0].IsDir() {
+		tree = filepath.Join(tree, infos[0].Name())
+	}
+
+	// the archive is staged in its own index, so the repo index and working
+	// tree are not touched until the commit is checked out. Ignored files are
+	// added too, since archives usually ship built code.
+	env := []string{"GIT_INDEX_FILE=" + filepath.Join(tmp, "index")}
+	if _, err := r.git(env, "--work-tree="+tree, "add", "--all", "--force", "."); err != nil {
+		return "", err
+	}
+
+	treeSHA, err := r.git(env, "write-tree")
+	if err != nil {
+		return "", err
+	}
+
+	headTree, err := r.git(nil, "rev-parse", r.head+"^{tree}")
+	if err != nil {
+		return "", err
+	}
+
+	if treeSHA == headTree {
+		r.logger().Info("No new updates")
+		return r.head, nil
+	}
+
+	message := fmt.Sprintf("Offline update from %s\n\nsha256: %s", filepath.Base(path), checksum)
+	commit, err := r.git(archiveCommitter, "commit-tree", treeSHA, "-p", r.head, "-m", message)
+	if err != nil {
+		return "", err
+	}
+
+	r.logger().WithField("new_version", commit).Info("Archive committed")
+	if err := r.checkout(commit); err != nil {
+		return "", err
+	}
+
+	return r.deploy("", trigger, false)
+}
+
+// git runs a git command in the clone, with the given environment variables,
+// and returns its trimmed output.
+func (r *Repo) git(env []string, args ...string) (string, error) {
+	cmd := r.command(r.gitPath(), "git", args...)
+	cmd.Env = r.env(env...)
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("git: %s: %s", args[0], lastLine(stderr.Bytes(), err))
+	}
+
+	return sanitizeOutput(out), nil
+}
+
+// isBundle returns true if the file is a git bundle.
+func isBundle(path string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	header := make([]byte, len(bundleSignatures[0]))
+	if _, err := io.ReadFull(f, header); err != nil && err != io.ErrUnexpectedEOF {
+		return false, err
+	}
+
+	for _, s := range bundleSignatures {
+		if bytes.Equal(header, s) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// verifyChecksum checks the file sha256. The checksum can be in the sha256sum
+// output format, eg: "<hex>  file.tar.gz".
+func verifyChecksum(path, checksum string) error {
+	fields := strings.Fields(checksum)
+	if len(fields) == 0 {
+		return fmt.Errorf("git: empty checksum for %s", path)
+	}
+	want := strings.ToLower(fields[0])
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return err
+	}
+
+	if got := hex.EncodeToString(h.Sum(nil)); got != want {
+		return fmt.Errorf("git: checksum mismatch for %s, got %s", path, got)
+	}
+
+	return nil
+}
+
+// extractArchive extracts the tar archive, gzipped or not, into dir. Entries
+// outside dir, entries below or replacing a symlink, symlinks that point
+// outside dir, and entries that are not directories, regular files or
+// symlinks, are refused, so nothing is ever written outside dir.
+func extractArchive(path, dir string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	br := bufio.NewReader(f)
+	var src io.Reader = br
+	if magic, err := br.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		src = gz
+	}
+
+	tr := tar.NewReader(src)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("git: invalid archive %s: %s", path, err.Error())
+		}
+
+		name := filepath.Clean(hdr.Name)
+		if !insideRoot(name) {
+			return fmt.Errorf("git: archive %s has an entry outside its root: %s", path, hdr.Name)
+		}
+		target := filepath.Join(dir, name)
+
+		if err := checkNoSymlinks(dir, name); err != nil {
+			return fmt.Errorf("git: archive %s has an entry through a symlink: %s", path, hdr.Name)
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+		case tar.TypeReg, tar.TypeRegA:
+			if err := extractFile(tr, target, os.FileMode(hdr.Mode).Perm()); err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			if filepath.IsAbs(hdr.Linkname) || !insideRoot(filepath.Join(filepath.Dir(name), hdr.Linkname)) {
+				return fmt.Errorf("git: archive %s has a symlink outside its root: %s -> %s", path, hdr.Name, hdr.Linkname)
+			}
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+			if err := os.Symlink(hdr.Linkname, target); err != nil {
+				return err
+			}
+		case tar.TypeXGlobalHeader:
+			// git archive stores the commit id in a global header.
+		default:
+			return fmt.Errorf("git: archive %s has an unsupported entry: %s", path, hdr.Name)
+		}
+	}
+}
+
+// copyFile copies the src file contents into dst.
+func copyFile(src, dst string) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return extractFile(f, dst, 0644)
+}
+
+func extractFile(r io.Reader, target string, perm os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(target, os.O_CREATE|os.O_TRUNC|os.O_WRONLY|syscall.O_NOFOLLOW, perm)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// insideRoot returns true if the relative path, once cleaned, stays inside the
+// directory it's relative to.
+func insideRoot(name string) bool {
+	name = filepath.Clean(name)
+	return !filepath.IsAbs(name) && name != ".." && !strings.HasPrefix(name, ".."+string(filepath.Separator))
+}
+
+// checkNoSymlinks returns an error if any component of the relative path, the
+// last one included, is an existing symlink under dir.
+func checkNoSymlinks(dir, name string) error {
+	p := dir
+	for _, part := range strings.Split(name, string(filepath.Separator)) {
+		p = filepath.Join(p, part)
+		info, err := os.Lstat(p)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("git: %s is a symlink", p)
+		}
+	}
+
+	return nil
+}
+
+// lastLine returns the last line of a git command output, or the command error
+// if there is no output.
+func lastLine(out []byte, err error) string {
+	s := sanitizeOutput(out)
+	if i := strings.LastIndexByte(s, '\n'); i >= 0 {
+		s = s[i+1:]
+	}
+	if len(s) == 0 {
+		return err.Error()
+	}
+
+	return s
+}
@@ -0,0 +1,189 @@
+package git
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/WiseGrowth/wisebot-operator/semver"
+)
+
+// RefKind represents what the repo source tracks.
+type RefKind string
+
+// Ref kinds
+const (
+	RefBranch RefKind = "branch"
+	RefTag    RefKind = "tag"
+	RefSemver RefKind = "semver"
+	RefCommit RefKind = "commit"
+)
+
+// commitSHA matches a full commit sha.
+var commitSHA = regexp.MustCompile("^[0-9a-f]{40}$")
+
+// Ref represents the repo source: the tip of a branch, a tag, the highest tag
+// matching a semver range, or a full commit sha. Branches follow every new
+// commit, the rest are pinned.
+type Ref struct {
+	Kind RefKind `json:"kind"`
+	Name string  `json:"name"`
+}
+
+// BranchRef returns a ref to the branch tip.
+func BranchRef(branch string) Ref {
+	return Ref{Kind: RefBranch, Name: branch}
+}
+
+// Validate checks the ref name is valid for its kind.
+func (ref Ref) Validate() error {
+	if len(ref.Name) == 0 {
+		return fmt.Errorf("git: %s ref without name", ref.Kind)
+	}
+
+	switch ref.Kind {
+	case RefBranch, RefTag:
+	case RefSemver:
+		if _, err := semver.ParseRange(ref.Name); err != nil {
+			return err
+		}
+	case RefCommit:
+		if !commitSHA.MatchString(ref.Name) {
+			return fmt.Errorf("git: %q is not a full commit sha", ref.Name)
+		}
+	default:
+		return fmt.Errorf("git: unknown ref kind %q", ref.Kind)
+	}
+
+	return nil
+}
+
+// pinned returns true if the ref does not follow a branch.
+func (ref Ref) pinned() bool {
+	return ref.Kind != RefBranch
+}
+
+// String returns the ref kind and name, eg: tag v1.2.0.
+func (ref Ref) String() string {
+	return fmt.Sprintf("%s %s", ref.Kind, ref.Name)
+}
+
+// resolve returns the revision the repo must be checked out at, and the tag
+// picked if the ref is a semver range. It only looks at the local objects, so
+// the repo must be fetched first to find new revisions.
+func (r *Repo) resolve() (rev string, tag string, err error) {
+	switch r.Ref.Kind {
+	case RefTag:
+		return "refs/tags/" + r.Ref.Name, r.Ref.Name, nil
+	case RefSemver:
+		tag, err := r.latestTag()
+		if err != nil {
+			return "", "", err
+		}
+		return "refs/tags/" + tag, tag, nil
+	case RefCommit:
+		return r.Ref.Name, "", nil
+	default:
+		return r.Branch, "", nil
+	}
+}
+
+// latestTag returns the highest tag matching the repo semver range.
+func (r *Repo) latestTag() (string, error) {
+	rng, err := semver.ParseRange(r.Ref.Name)
+	if err != nil {
+		return "", err
+	}
+
+	list := r.command(r.gitPath(), "git", "tag", "--list")
+	out, err := list.Output()
+	if err != nil {
+		return "", err
+	}
+
+	var latest string
+	var latestVersion semver.Version
+	for _, tag := range strings.Fields(string(out)) {
+		v, err := semver.Parse(tag)
+		if err != nil || !rng.Match(v) {
+			continue
+		}
+
+		if len(latest) == 0 || v.Compare(latestVersion) > 0 {
+			latest, latestVersion = tag, v
+		}
+	}
+
+	if len(latest) == 0 {
+		return "", fmt.Errorf("git: no tag matches %q in %s", r.Ref.Name, r.Path)
+	}
+
+	return latest, nil
+}
+
+// fetch downloads the remote objects. Pinned refs also fetch the tags, and
+// commits that are not in any fetched branch are fetched by their sha.
+func (r *Repo) fetch() error {
+	args := []string{"fetch", upstreamBase}
+	if r.Ref.pinned() {
+		args = []string{"fetch", "--tags", "--force", upstreamBase}
+	}
+
+	fetch := r.command(r.gitPath(), "git", args...)
+	if err := fetch.Run(); err != nil {
+		return err
+	}
+
+	if r.Ref.Kind != RefCommit || r.hasCommit(r.Ref.Name) {
+		return nil
+	}
+
+	fetch = r.command(r.gitPath(), "git", "fetch", upstreamBase, r.Ref.Name)
+	return fetch.Run()
+}
+
+// hasCommit returns true if the revision exists locally.
+func (r *Repo) hasCommit(rev string) bool {
+	check := r.command(r.gitPath(), "git", "cat-file", "-e", rev+"^{commit}")
+	return check.Run() == nil
+}
+
+// revParse returns the short sha of the revision.
+func (r *Repo) revParse(rev string) (string, error) {
+	revParse := r.command(r.gitPath(), "git", "rev-parse", "--short", rev+"^{commit}")
+	out, err := revParse.Output()
+	if err != nil {
+		return "", err
+	}
+
+	return sanitizeOutput(out), nil
+}
+
+// commitSHA returns the full sha of the revision.
+func (r *Repo) commitSHA(rev string) (string, error) {
+	revParse := r.command(r.gitPath(), "git", "rev-parse", rev+"^{commit}")
+	out, err := revParse.Output()
+	if err != nil {
+		return "", err
+	}
+
+	return sanitizeOutput(out), nil
+}
+
+// checkout moves the repo working tree to the revision. Branches are reset, so
+// the local branch follows its upstream. Pinned refs are checked out detached.
+// Repos that keep releases prepare a new release instead, and the working tree
+// units run is not touched until the release is activated.
+func (r *Repo) checkout(rev string) error {
+	if r.keepsReleases() {
+		return r.prepareRelease(rev)
+	}
+
+	args := []string{"reset", "--hard", rev}
+	if r.Ref.pinned() {
+		args = []string{"checkout", "--force", "--detach", rev}
+	}
+
+	checkout := r.command(r.Path, "git", args...)
+	return checkout.Run()
+}
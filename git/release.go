@@ -0,0 +1,225 @@
+package git
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// Releases layout, relative to the repo path. The clone is only used to fetch
+// and its working tree is not checked out. Every release is a git worktree
+// named after its full commit sha, and `current` points to the one units run.
+const (
+	releasesCloneDir   = "repo"
+	releasesDir        = "releases"
+	releasesCurrentDir = "current"
+)
+
+// keepsReleases returns true if the repo checks out every revision into its
+// own release directory, instead of updating its working tree in place.
+func (r *Repo) keepsReleases() bool {
+	return r.Releases > 0
+}
+
+// gitPath returns the path of the clone the git commands run in.
+func (r *Repo) gitPath() string {
+	if r.keepsReleases() {
+		return filepath.Join(r.Path, releasesCloneDir)
+	}
+
+	return r.Path
+}
+
+// CurrentPath returns the path of the code the units run: the repo path, or
+// the `current` symlink if the repo keeps releases. The symlink is resolved
+// when a unit starts, so running units keep their release until restarted.
+func (r *Repo) CurrentPath() string {
+	if r.keepsReleases() {
+		return filepath.Join(r.Path, releasesCurrentDir)
+	}
+
+	return r.Path
+}
+
+// WorkTree returns the directory the post receive hooks run in: the release
+// being prepared, if any, or the current code path.
+func (r *Repo) WorkTree() string {
+	if len(r.pending) > 0 {
+		return r.pending
+	}
+
+	return r.CurrentPath()
+}
+
+// newWorkTree returns true if the hooks work tree is a release created for the
+// deployment in progress.
+func (r *Repo) newWorkTree() bool {
+	return len(r.pending) > 0 && r.pendingNew
+}
+
+// hasCurrentRelease returns true if the `current` symlink exists.
+func (r *Repo) hasCurrentRelease() bool {
+	_, err := os.Lstat(r.CurrentPath())
+	return err == nil
+}
+
+// migrate moves an in-place clone into the releases layout, so enabling the
+// releases does not clone the repo again. Going back to an in-place clone is
+// not supported.
+func (r *Repo) migrate() error {
+	inPlace := dirExists(filepath.Join(r.Path, ".git"))
+	releases := dirExists(filepath.Join(r.Path, releasesCloneDir, ".git"))
+
+	switch {
+	case !r.keepsReleases() && releases && !inPlace:
+		return fmt.Errorf("git: %s uses the releases layout, remove it to check out the repo in place", r.Path)
+	case !r.keepsReleases() || !inPlace:
+		return nil
+	}
+
+	r.logger().Info("Moving the repo into the releases layout")
+	tmp := r.Path + ".migrating"
+	if err := os.Rename(r.Path, tmp); err != nil {
+		return err
+	}
+
+	if err := r.mkdirAll(r.Path); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, r.gitPath())
+}
+
+// prepareRelease checks out the revision into its release directory, which
+// becomes the hooks work tree until it's activated or discarded. Kept releases
+// are reused, so rolling back to them is quick.
+func (r *Repo) prepareRelease(rev string) error {
+	sha, err := r.commitSHA(rev)
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Join(r.Path, releasesDir, sha)
+	if dirExists(dir) {
+		checkout := r.command(dir, "git", "checkout", "--force", "--detach", sha)
+		if err := checkout.Run(); err != nil {
+			return err
+		}
+
+		r.pending, r.pendingNew = dir, false
+		return nil
+	}
+
+	if err := r.mkdirAll(filepath.Dir(dir)); err != nil {
+		return err
+	}
+
+	// forget the worktrees whose directories were removed by hand.
+	prune := r.command(r.gitPath(), "git", "worktree", "prune")
+	prune.Run()
+
+	add := r.command(r.gitPath(), "git", "worktree", "add", "--force", "--detach", dir, sha)
+	if err := add.Run(); err != nil {
+		return err
+	}
+
+	r.pending, r.pendingNew = dir, true
+	return nil
+}
+
+// activateRelease points the `current` symlink to the prepared release. The
+// symlink is replaced with a rename, so units never see a missing or half
+// updated release. Then the oldest releases are removed.
+func (r *Repo) activateRelease() error {
+	target, err := filepath.Rel(r.Path, r.pending)
+	if err != nil {
+		return err
+	}
+
+	tmp := r.CurrentPath() + ".tmp"
+	os.Remove(tmp)
+	if err := os.Symlink(target, tmp); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp, r.CurrentPath()); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	// the modification time orders the releases by activation when pruning.
+	now := time.Now()
+	os.Chtimes(r.pending, now, now)
+	r.pending = ""
+
+	r.pruneReleases()
+	return nil
+}
+
+// discardRelease forgets the prepared release, removing it if it was created
+// for this deployment.
+func (r *Repo) discardRelease() {
+	if r.pendingNew {
+		r.removeRelease(r.pending)
+	}
+
+	r.pending = ""
+}
+
+// pruneReleases removes the oldest releases, keeping the current one and the
+// last activated ones up to the repo Releases.
+func (r *Repo) pruneReleases() {
+	dir := filepath.Join(r.Path, releasesDir)
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		r.logger().WithField("error", err).Warn("Could not list the releases")
+		return
+	}
+
+	current, _ := os.Readlink(r.CurrentPath())
+	current = filepath.Base(current)
+
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].ModTime().After(infos[j].ModTime())
+	})
+
+	kept := 1 // the current release
+	for _, info := range infos {
+		if !info.IsDir() || info.Name() == current {
+			continue
+		}
+
+		if kept < r.Releases {
+			kept++
+			continue
+		}
+
+		r.removeRelease(filepath.Join(dir, info.Name()))
+	}
+}
+
+// removeRelease removes the release worktree.
+func (r *Repo) removeRelease(dir string) {
+	r.logger().WithField("release", filepath.Base(dir)).Info("Removing release")
+
+	remove := r.command(r.gitPath(), "git", "worktree", "remove", "--force", dir)
+	if err := remove.Run(); err == nil {
+		return
+	}
+
+	// the worktree could be already unregistered, or locked.
+	if err := os.RemoveAll(dir); err != nil {
+		r.logger().WithField("error", err).Warn("Could not remove the release")
+	}
+
+	prune := r.command(r.gitPath(), "git", "worktree", "prune")
+	prune.Run()
+}
+
+func dirExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
@@ -6,10 +6,9 @@ import (
 	"errors"
 	"fmt"
 	"os"
-	"os/exec"
 	"path"
-	"runtime"
 	"strings"
+	"syscall"
 
 	"github.com/sirupsen/logrus"
 
@@ -17,8 +16,6 @@ import (
 )
 
 const (
-	onOSX = (runtime.GOOS == "darwin")
-
 	// upstream must have the following format: remote/branch
 	upstreamBase = "origin"
 )
@@ -26,6 +23,9 @@ const (
 // Errors
 var (
 	ErrWrongUpstream = errors.New("git: upstream is not valid")
+	// ErrUntrustedUpload is returned when a file is uploaded through http to
+	// a repo without trusted keys.
+	ErrUntrustedUpload = errors.New("git: uploaded files are only accepted by repos with trusted keys")
 )
 
 // Repo represents a git repo, it contains its path and remote. This struct has
@@ -34,23 +34,55 @@ var (
 type Repo struct {
 	Path   string `json:"path"`
 	Remote string `json:"remote"`
-	Branch string `json:"branch"`
-
-	name string
-	head string
+	Branch string `json:"branch"` // upstream branch, empty if the ref is pinned
+	Ref    Ref    `json:"ref"`
+	// Releases is the number of releases kept, 0 means the working tree is
+	// updated in place.
+	Releases int `json:"releases,omitempty"`
+	// TrustedKeys are the keys that must sign every revision deployed, nil
+	// means signatures are not verified.
+	TrustedKeys *TrustedKeys `json:"trusted_keys,omitempty"`
+	// Credential is the user and groups git and the hooks run as, so the repo
+	// belongs to them and they can use their ssh keys. If nil, they run as
+	// the operator user.
+	Credential *syscall.Credential `json:"-"`
+	// Env is appended to the operator environment of git and the hooks, eg:
+	// the HOME of the credential user.
+	Env []string `json:"-"`
+
+	name      string
+	head      string
+	tag       string          // tag the repo is checked out at, if the ref is a tag or a range
+	untrusted *SignatureError // last revision refused, nil if none
+
+	pending    string // release being prepared, empty if none
+	pendingNew bool   // true if the pending release was created for this deployment
+
+	offlineFile string // file the repo is being updated from, empty if none
 
 	postReceiveHooks []PostReceiveHook
 }
 
-// NewRepo initialize and returns a repository pointer.
+// NewRepo initialize and returns a repository pointer that tracks the branch.
 func NewRepo(repoPath, remote string, branch string, postReceiveHooks ...PostReceiveHook) *Repo {
-	return &Repo{
+	return NewRepoAt(repoPath, remote, BranchRef(branch), postReceiveHooks...)
+}
+
+// NewRepoAt initialize and returns a repository pointer whose source is the
+// given ref.
+func NewRepoAt(repoPath, remote string, ref Ref, postReceiveHooks ...PostReceiveHook) *Repo {
+	r := &Repo{
 		name:             path.Base(repoPath),
 		Path:             repoPath,
 		Remote:           remote,
-		Branch:           upstreamBase + "/" + branch,
+		Ref:              ref,
 		postReceiveHooks: postReceiveHooks,
 	}
+	if !ref.pinned() {
+		r.Branch = upstreamBase + "/" + ref.Name
+	}
+
+	return r
 }
 
 type rawRepo Repo
@@ -60,64 +92,112 @@ func (r *Repo) MarshalJSON() ([]byte, error) {
 	return json.Marshal(struct {
 		*rawRepo
 		Version string `json:"version"`
+		Tag     string `json:"tag,omitempty"`
 	}{
 		rawRepo: (*rawRepo)(r),
 		Version: r.CurrentHead(),
+		Tag:     r.tag,
 	})
 }
 
+// RefState represents the ref the repo tracks, the tag it's checked out at if
+// the ref is a tag or a semver range, and the last revision refused because it
+// was not signed by a trusted key.
+type RefState struct {
+	Ref
+	Tag       string          `json:"tag,omitempty"`
+	Untrusted *SignatureError `json:"untrusted,omitempty"`
+}
+
+// CurrentRef returns the ref the repo tracks, the tag it's checked out at and
+// the last revision refused.
+func (r *Repo) CurrentRef() RefState {
+	return RefState{Ref: r.Ref, Tag: r.tag, Untrusted: r.untrusted}
+}
+
 // PostReceiveHook is a function that runs after clonning and updating the repo.
 type PostReceiveHook func(*Repo) error
 
-// Update runs a git fetch to the `origin` remote, if the origin/master has a
-// different sha that the current head, it executes a
-// `git reset --hard origin/master` and then runs the repository post receive
-// hooks. The function must return the new head sha if succeeds. If no updates
-// are found, it returns the actual head SHA.
+// postReceiveHookPresets maps the preset names used in the units manifest to
+// its PostReceiveHook.
+var postReceiveHookPresets = map[string]PostReceiveHook{
+	"yarn-install": YarnInstallHook,
+	"npm-install":  NpmInstallHook,
+	"npm-prune":    NpmPruneHook,
+}
+
+// PostReceiveHookByName returns the PostReceiveHook preset registered with the
+// given name. If the preset does not exists, it returns a nil hook and a false
+// value.
+func PostReceiveHookByName(name string) (hook PostReceiveHook, ok bool) {
+	hook, ok = postReceiveHookPresets[name]
+	return hook, ok
+}
+
+// Update runs a git fetch to the `origin` remote, if the revision the ref
+// resolves to (eg: origin/master, or the highest tag matching a semver range)
+// has a different sha that the current head, and it's signed by a trusted key
+// if the repo has trusted keys, it checks it out with a
+// `git reset --hard origin/master`, or a detached checkout for pinned refs, and
+// then runs the repository post receive hooks. The function must return the
+// new head sha if succeeds. If no updates are found, it returns the actual
+// head SHA.
 func (r *Repo) Update() (updatedHeadSHA string, err error) {
+	return r.UpdateBy(TriggerUpdate)
+}
+
+// UpdateBy works like Update, and records the trigger in the deployment
+// history if a new revision is checked out.
+func (r *Repo) UpdateBy(trigger string) (updatedHeadSHA string, err error) {
+	r.logger().Info("Updating")
+
+	if err := r.fetch(); err != nil {
+		return "", err
+	}
+
+	return r.sync(trigger)
+}
+
+// sync checks out the revision the ref resolves to, if it's not the current
+// head, and runs the post receive hooks. It only looks at the local objects.
+func (r *Repo) sync(trigger string) (string, error) {
 	log := r.logger()
-	log.Info("Updating")
 
-	fetch := exec.Command("git", "fetch", "origin")
-	fetch.Dir = r.Path
-	if err := fetch.Run(); err != nil {
+	rev, tag, err := r.resolve()
+	if err != nil {
 		return "", err
 	}
 
-	originHeadCmd := exec.Command("git", "rev-parse", "--short", r.Branch)
-	originHeadCmd.Dir = r.Path
-	originHead, err := originHeadCmd.Output()
+	oHead, err := r.revParse(rev)
 	if err != nil {
 		return "", err
 	}
 
-	oHead := sanitizeOutput(originHead)
 	if oHead == r.head {
+		r.tag = tag
+		r.untrusted = nil
 		log.Info("No new updates")
 		return r.head, nil
 	}
-	log.Info("Update found")
 
-	updateCmd := exec.Command("git", "reset", "--hard", r.Branch)
-	updateCmd.Dir = r.Path
-
-	log = log.WithFields(logrus.Fields{"new_version": oHead})
-	log.Info("Downloading")
-	if err := updateCmd.Run(); err != nil {
-		return "", err
+	if trigger == TriggerBootstrap && r.rolledBackFrom()[oHead] {
+		log.WithField("new_version", oHead).Info("Rolled back from this version, keeping the current one")
+		return r.head, nil
 	}
+	log.Info("Update found")
 
-	if err := r.updateHead(); err != nil {
+	if err := r.verify(rev); err != nil {
 		return "", err
 	}
 
-	log.Info("Update finished")
-	if err := r.runPostReceiveHooks(); err != nil {
-		log.Debugf("Error when running hooks: %s\n", err.Error())
+	log = log.WithFields(logrus.Fields{"new_version": oHead, "tag": tag})
+	log.Info("Downloading")
+	if err := r.checkout(rev); err != nil {
 		return "", err
 	}
 
-	return r.head, nil
+	log.Info("Update finished")
+	return r.deploy(tag, trigger, false)
 }
 
 // CurrentHead returns the head sha as a string.
@@ -139,11 +219,26 @@ func (r *Repo) runPostReceiveHooks() error {
 // Bootstrap clones the repo if it not exists and runs the post-receive hooks.
 // If there is no errors, it updates the repository current head sha. If the
 // repo is already cloned, the function receives an arguments that indicates
-// if we want to update (git pull) the repo or not.
+// if we want to update (git pull) the repo or not. Pinned refs are checked out
+// from the local objects even if we don't want to update, so changing the ref
+// in the manifest takes effect offline when possible, unless the repo was
+// updated from a file. Revisions the repo was rolled back from are not checked
+// out again on bootstrap, until the ref moves past them. Every revision checked
+// out is recorded in the deployment history. Repos that keep releases and have
+// no current release yet check out their first one offline. Repos with trusted
+// keys refuse to bootstrap a head without a verified deployment.
 func (r *Repo) Bootstrap(wantToUpdate bool) error {
 	updated := false
 
-	if _, err := os.Stat(fmt.Sprintf("%s/.git", r.Path)); err != nil {
+	if err := r.takeOver(); err != nil {
+		return err
+	}
+
+	if err := r.migrate(); err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(fmt.Sprintf("%s/.git", r.gitPath())); err != nil {
 		if os.IsExist(err) {
 			return err
 		}
@@ -153,23 +248,44 @@ func (r *Repo) Bootstrap(wantToUpdate bool) error {
 
 		logger.Info("Clonning")
 
-		branchIndex := strings.Index(r.Branch, "/")
-		if branchIndex < 1 {
-			return ErrWrongUpstream
+		// the working tree of the releases clone is never used, and repos with
+		// trusted keys must not check out the remote tip before verifying it.
+		args := []string{"clone"}
+		if r.keepsReleases() || r.TrustedKeys != nil {
+			args = append(args, "--no-checkout")
 		}
-		branch := r.Branch[branchIndex+1:]
-		clone := exec.Command("git", "clone", "--single-branch", "--branch", branch, r.Remote, r.Path)
-		clone.Dir = path.Dir(r.Path)
 
-		if err := clone.Run(); err != nil {
+		// pinned refs can point to any branch, so the whole repo is cloned.
+		if !r.Ref.pinned() {
+			branchIndex := strings.Index(r.Branch, "/")
+			if branchIndex < 1 {
+				return ErrWrongUpstream
+			}
+			branch := r.Branch[branchIndex+1:]
+			args = append(args, "--single-branch", "--branch", branch)
+		}
+
+		clone := r.command(path.Dir(r.gitPath()), "git", append(args, r.Remote, r.gitPath())...)
+		if err := r.mkdirAll(clone.Dir); err != nil {
 			return err
 		}
 
-		if err := r.updateHead(); err != nil {
+		if err := clone.Run(); err != nil {
 			return err
 		}
 
-		if err := r.runPostReceiveHooks(); err != nil {
+		if r.Ref.pinned() {
+			if err := r.fetch(); err != nil {
+				return err
+			}
+		}
+
+		if _, err := r.sync(TriggerBootstrap); err != nil {
+			return err
+		}
+	} else if r.keepsReleases() && !r.hasCurrentRelease() {
+		updated = true
+		if _, err := r.sync(TriggerBootstrap); err != nil {
 			return err
 		}
 	}
@@ -178,10 +294,32 @@ func (r *Repo) Bootstrap(wantToUpdate bool) error {
 		return err
 	}
 
-	if !updated && wantToUpdate {
-		if _, err := r.Update(); err != nil {
+	// a head that was never verified, eg: checked out before the repo had
+	// trusted keys, is deployed again so it's verified before any unit runs.
+	if r.TrustedKeys != nil && !updated && !r.headVerified() {
+		r.logger().Warn("Current version was never verified, deploying it again")
+		r.head = ""
+		if !wantToUpdate {
+			if _, err := r.sync(TriggerBootstrap); err != nil {
+				return err
+			}
+		}
+	}
+
+	switch {
+	case updated:
+	case wantToUpdate:
+		if _, err := r.UpdateBy(TriggerBootstrap); err != nil {
 			return err
 		}
+	case r.Ref.pinned():
+		if d, ok := r.lastDeployment(); ok && d.SHA == r.head && len(d.File) > 0 {
+			r.logger().Info("Updated offline, keeping the current version")
+			break
+		}
+		if _, err := r.sync(TriggerBootstrap); err != nil {
+			r.logger().WithField("error", err).Warn("Could not check out the pinned ref offline, keeping the current version")
+		}
 	}
 
 	return nil
@@ -192,22 +330,33 @@ func (r *Repo) logger() *logrus.Entry {
 		"process":      r.name,
 		"repo_version": r.head,
 		"repo":         r.Path,
+		"ref_kind":     r.Ref.Kind,
+		"ref":          r.Ref.Name,
 	})
 }
 
 func (r *Repo) updateHead() error {
-	headCmd := exec.Command("git", "log", "--pretty=format:%h", "-n", "1")
-	headCmd.Dir = r.Path
-
-	head, err := headCmd.Output()
+	head, err := r.headAt(r.CurrentPath())
 	if err != nil {
 		return err
 	}
 
-	r.head = sanitizeOutput(head)
+	r.head = head
 	return nil
 }
 
+// headAt returns the head sha of the working tree located at dir.
+func (r *Repo) headAt(dir string) (string, error) {
+	headCmd := r.command(dir, "git", "log", "--pretty=format:%h", "-n", "1")
+
+	head, err := headCmd.Output()
+	if err != nil {
+		return "", err
+	}
+
+	return sanitizeOutput(head), nil
+}
+
 // AddPostReceiveHooks receives one or multiple PostReceiveHooks and appends
 // them to the repo `postReceiveHooks` private attribute.
 func (r *Repo) AddPostReceiveHooks(handlers ...PostReceiveHook) {
@@ -224,8 +373,7 @@ func YarnInstallHook(r *Repo) error {
 	var bout bytes.Buffer
 	var berr bytes.Buffer
 
-	yarnInstall := exec.Command("yarn", "install", "--production")
-	yarnInstall.Dir = r.Path
+	yarnInstall := r.command(r.WorkTree(), "yarn", "install", "--production")
 	yarnInstall.Stdout = &bout
 	yarnInstall.Stderr = &berr
 
@@ -248,8 +396,7 @@ func NpmInstallHook(r *Repo) error {
 	var bout bytes.Buffer
 	var berr bytes.Buffer
 
-	npmInstall := exec.Command("npm", "install", "--production")
-	npmInstall.Dir = r.Path
+	npmInstall := r.command(r.WorkTree(), "npm", "install", "--production")
 	npmInstall.Stdout = &bout
 	npmInstall.Stderr = &berr
 
@@ -271,11 +418,7 @@ func NpmPruneHook(r *Repo) error {
 	var bout bytes.Buffer
 	var berr bytes.Buffer
 
-	prune := exec.Command("sudo", "npm", "prune")
-	if onOSX {
-		prune = exec.Command("npm", "prune")
-	}
-	prune.Dir = r.Path
+	prune := r.command(r.WorkTree(), "npm", "prune")
 	prune.Stdout = &bout
 	prune.Stderr = &berr
 
@@ -0,0 +1,83 @@
+package git
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// TrustedKeys represents the keys trusted to sign the revisions the repo
+// deploys. GPGHome is a GnuPG home directory with the trusted public keys
+// imported, and SSHAllowedSigners is an ssh allowed signers file. Signatures
+// of a kind without keys are never trusted, so the operator user keyrings and
+// git config are not taken into account.
+type TrustedKeys struct {
+	GPGHome           string `json:"gpg_home,omitempty"`
+	SSHAllowedSigners string `json:"ssh_allowed_signers,omitempty"`
+}
+
+// SignatureError is returned when the revision to deploy is not signed by a
+// trusted key, so it's refused.
+type SignatureError struct {
+	SHA    string    `json:"sha"`
+	Reason string    `json:"reason"`
+	At     time.Time `json:"at"`
+}
+
+func (e *SignatureError) Error() string {
+	return fmt.Sprintf("git: refusing %s, no trusted signature: %s", e.SHA, e.Reason)
+}
+
+// verify checks that the revision is signed by a trusted key, if the repo has
+// trusted keys. Tags are verified as signed tags, the rest as signed commits.
+// The last refused revision is reported in the repo ref state until a revision
+// is verified.
+func (r *Repo) verify(rev string) error {
+	if r.TrustedKeys == nil {
+		return nil
+	}
+
+	// an empty allowed signers file trusts no ssh key, and `false` fails
+	// every gpg verification.
+	signers := os.DevNull
+	if len(r.TrustedKeys.SSHAllowedSigners) > 0 {
+		signers = r.TrustedKeys.SSHAllowedSigners
+	}
+	args := []string{
+		"-c", "gpg.ssh.allowedSignersFile=" + signers,
+		"-c", "gpg.x509.program=false",
+	}
+	if len(r.TrustedKeys.GPGHome) == 0 {
+		args = append(args, "-c", "gpg.program=false")
+	}
+
+	if tag := strings.TrimPrefix(rev, "refs/tags/"); tag != rev {
+		args = append(args, "verify-tag", tag)
+	} else {
+		args = append(args, "verify-commit", rev)
+	}
+
+	verify := r.command(r.gitPath(), "git", args...)
+	if len(r.TrustedKeys.GPGHome) > 0 {
+		verify.Env = r.env("GNUPGHOME=" + r.TrustedKeys.GPGHome)
+	}
+
+	out, err := verify.CombinedOutput()
+	if err == nil {
+		r.untrusted = nil
+		return nil
+	}
+
+	sha, _ := r.revParse(rev)
+	// unsigned revisions and unverifiable signatures print nothing.
+	reason := "no signature could be verified"
+	if len(out) > 0 {
+		reason = lastLine(out, err)
+	}
+
+	r.untrusted = &SignatureError{SHA: sha, Reason: reason, At: time.Now()}
+	r.logger().WithField("error", r.untrusted.Error()).Warn("Untrusted revision refused")
+
+	return r.untrusted
+}
@@ -0,0 +1,108 @@
+package git
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+)
+
+// command returns the command that runs in dir as the repo user, with the
+// operator environment plus the repo Env.
+func (r *Repo) command(dir, name string, args ...string) *exec.Cmd {
+	cmd := exec.Command(name, args...)
+	cmd.Dir = dir
+	cmd.Env = r.env()
+	if r.Credential != nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{Credential: r.Credential}
+	}
+
+	return cmd
+}
+
+// env returns the environment of the repo commands with the given variables,
+// or nil if it's the operator one.
+func (r *Repo) env(vars ...string) []string {
+	if len(r.Env) == 0 && len(vars) == 0 {
+		return nil
+	}
+
+	env := append(os.Environ(), r.Env...)
+	return append(env, vars...)
+}
+
+// mkdirAll creates the directory and its missing parents, owned by the repo
+// user, so git can write to them.
+func (r *Repo) mkdirAll(dir string) error {
+	var missing []string
+	for p := dir; !dirExists(p); p = filepath.Dir(p) {
+		missing = append(missing, p)
+		if p == filepath.Dir(p) {
+			break
+		}
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	if r.Credential == nil {
+		return nil
+	}
+
+	for _, p := range missing {
+		if err := os.Chown(p, int(r.Credential.Uid), int(r.Credential.Gid)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// takeOver gives the repo to its user if it belongs to another one, eg: when
+// it was cloned by the operator user.
+func (r *Repo) takeOver() error {
+	if r.Credential == nil {
+		return nil
+	}
+
+	info, err := os.Stat(r.Path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if st, ok := info.Sys().(*syscall.Stat_t); ok && st.Uid == r.Credential.Uid {
+		return nil
+	}
+
+	r.logger().Info("Giving the repo to its user")
+	return r.chownAll(r.Path)
+}
+
+// chownAll gives the directory and everything in it to the repo user.
+func (r *Repo) chownAll(dir string) error {
+	if r.Credential == nil {
+		return nil
+	}
+
+	uid, gid := int(r.Credential.Uid), int(r.Credential.Gid)
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		return os.Lchown(path, uid, gid)
+	})
+}
+
+// ownedByRepoUser returns true if the credential is the one of the repo user.
+func (r *Repo) ownedByRepoUser(c *syscall.Credential) bool {
+	if r.Credential == nil {
+		return c == nil || int(c.Uid) == os.Getuid()
+	}
+
+	return c != nil && c.Uid == r.Credential.Uid
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/WiseGrowth/wisebot-operator/command"
+)
+
+const (
+	// maxServiceTransitions is the number of status transitions kept per
+	// service.
+	maxServiceTransitions = 20
+)
+
+// transition represents a service status change.
+type transition struct {
+	From command.Status `json:"from"`
+	To   command.Status `json:"to"`
+	At   time.Time      `json:"at"`
+}
+
+// serviceHistory records the service lifecycle: when it started and exited,
+// how it exited, how many times it was restarted and its last status
+// transitions.
+type serviceHistory struct {
+	mu sync.RWMutex
+
+	startedAt    time.Time
+	exitedAt     time.Time
+	exitCode     int
+	exitSignal   string
+	restartCount int
+	status       command.Status
+	transitions  []transition
+}
+
+func newServiceHistory() *serviceHistory {
+	return &serviceHistory{status: command.StatusIdle, exitCode: -1}
+}
+
+// MarshalJSON implements json marshal interface
+func (h *serviceHistory) MarshalJSON() ([]byte, error) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	return json.Marshal(struct {
+		StartedAt    *time.Time   `json:"started_at"`
+		ExitedAt     *time.Time   `json:"exited_at"`
+		ExitCode     *int         `json:"exit_code"`
+		ExitSignal   string       `json:"exit_signal,omitempty"`
+		RestartCount int          `json:"restart_count"`
+		Transitions  []transition `json:"transitions"`
+	}{
+		StartedAt:    timeOrNil(h.startedAt),
+		ExitedAt:     timeOrNil(h.exitedAt),
+		ExitCode:     intOrNil(h.exitCode),
+		ExitSignal:   h.exitSignal,
+		RestartCount: h.restartCount,
+		Transitions:  h.transitions,
+	})
+}
+
+// record appends a transition if the status changed.
+func (h *serviceHistory) record(status command.Status) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.recordLocked(status)
+}
+
+func (h *serviceHistory) recordLocked(status command.Status) {
+	if status == h.status {
+		return
+	}
+
+	h.transitions = append(h.transitions, transition{From: h.status, To: status, At: time.Now()})
+	if len(h.transitions) > maxServiceTransitions {
+		h.transitions = h.transitions[len(h.transitions)-maxServiceTransitions:]
+	}
+	h.status = status
+}
+
+// started records the command start. Every start after the first one counts
+// as a restart.
+func (h *serviceHistory) started() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if !h.startedAt.IsZero() {
+		h.restartCount++
+	}
+	h.startedAt = time.Now()
+	h.recordLocked(command.StatusRunning)
+}
+
+// exited records how the command exited and its final status.
+func (h *serviceHistory) exited(cmd *command.Command) {
+	code, signal := cmd.ExitState()
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.exitedAt = time.Now()
+	h.exitCode = code
+	h.exitSignal = ""
+	if signal != nil {
+		h.exitSignal = signal.String()
+	}
+	h.recordLocked(cmd.Status())
+}
+
+func timeOrNil(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+
+	return &t
+}
+
+func intOrNil(i int) *int {
+	if i < 0 {
+		return nil
+	}
+
+	return &i
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"github.com/WiseGrowth/wisebot-operator/command"
+	"github.com/WiseGrowth/wisebot-operator/manifest"
+)
+
+// Service lifecycle hook stages
+const (
+	hookPreStart  = "pre_start"
+	hookPostStart = "post_start"
+	hookPostStop  = "post_stop"
+)
+
+// serviceHooks represents the commands run around the service lifecycle.
+type serviceHooks struct {
+	preStart  []command.Hook
+	postStart []command.Hook
+	postStop  []command.Hook
+}
+
+// hookFailure represents the last failed service hook.
+type hookFailure struct {
+	Stage string `json:"stage"`
+	*command.HookError
+}
+
+// runHooks runs the stage hooks in order, stopping at the first failure. The
+// failure is reported in the service json until the service starts again.
+func (s *Service) runHooks(stage string, hooks []command.Hook) error {
+	cmd := s.command()
+	for _, h := range hooks {
+		log := s.logger().WithField("hook", h.Slug()).WithField("stage", stage)
+		log.Debug("Running hook")
+
+		err := cmd.RunHook(h)
+		if err == nil {
+			continue
+		}
+
+		log.Error(err)
+		if e, ok := err.(*command.HookError); ok {
+			s.mu.Lock()
+			s.hookFailure = &hookFailure{Stage: stage, HookError: e}
+			s.mu.Unlock()
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+// lastHookFailure returns the last failed hook since the service started, if
+// any.
+func (s *Service) lastHookFailure() *hookFailure {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.hookFailure
+}
+
+// newUnitHooks builds the service hooks declared in the manifest. The hook
+// executables and their arguments can reference the home directory using `~`.
+func newUnitHooks(u manifest.Unit) (serviceHooks, error) {
+	var hooks serviceHooks
+	stages := []struct {
+		declared []manifest.Hook
+		dst      *[]command.Hook
+	}{
+		{u.PreStart, &hooks.preStart},
+		{u.PostStart, &hooks.postStart},
+		{u.PostStop, &hooks.postStop},
+	}
+
+	for _, stage := range stages {
+		for _, h := range stage.declared {
+			exec := make([]string, len(h.Exec))
+			for i, arg := range h.Exec {
+				var err error
+				exec[i], err = expandHome(arg)
+				if err != nil {
+					return hooks, err
+				}
+			}
+
+			*stage.dst = append(*stage.dst, command.Hook{
+				Name:    exec[0],
+				Args:    exec[1:],
+				Timeout: h.Timeout.Duration,
+			})
+		}
+	}
+
+	return hooks, nil
+}
@@ -5,12 +5,16 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
-	"os/exec"
+	"os"
+	"path/filepath"
+	"strconv"
 	"time"
 
 	"github.com/WiseGrowth/go-wisebot/logger"
 	"github.com/WiseGrowth/go-wisebot/rasp"
 	"github.com/WiseGrowth/wisebot-operator/daemon"
+	"github.com/WiseGrowth/wisebot-operator/git"
+	"github.com/WiseGrowth/wisebot-operator/procstat"
 	"github.com/julienschmidt/httprouter"
 	"github.com/urfave/negroni"
 )
@@ -20,6 +24,8 @@ type key int
 const (
 	httpPort = 5000
 
+	defaultServiceLogsLines = 100
+
 	loggerKey key = iota
 )
 
@@ -31,6 +37,7 @@ type healthResponse struct {
 type healthzDataResponse struct {
 	Services *ServiceStore `json:"services"`
 	Daemons  *daemon.Store `json:"daemons"`
+	Jobs     *JobStore     `json:"jobs"`
 }
 
 type healthzMetaResponse struct {
@@ -45,6 +52,33 @@ type manageServiceHTTPRequest struct {
 	Name string `json:"name"`
 }
 
+// rollbackHTTPRequest represents the body for rolling back daemons and
+// services. An empty version rolls back to the previous deployment.
+type rollbackHTTPRequest struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type serviceLogsResponse struct {
+	Name  string   `json:"name"`
+	Lines []string `json:"lines"`
+}
+
+type serviceHistoryResponse struct {
+	Name    string          `json:"name"`
+	History *serviceHistory `json:"history"`
+}
+
+type deploymentsResponse struct {
+	Name        string           `json:"name"`
+	Deployments []git.Deployment `json:"deployments"`
+}
+
+type metricsResponse struct {
+	Name    string            `json:"name"`
+	Samples []procstat.Sample `json:"samples"`
+}
+
 type mqttStatus struct {
 	IsConnected bool `json:"is_connected"`
 }
@@ -119,6 +153,7 @@ func newHealthResponse() *healthResponse {
 	data := new(healthzDataResponse)
 	data.Services = processManager.Services
 	data.Daemons = daemonStore
+	data.Jobs = unitLoader.Jobs
 
 	meta := new(healthzMetaResponse)
 	meta.MQTTStatus.IsConnected = processManager.MQTTClient.IsConnected()
@@ -225,13 +260,98 @@ func updateServiceHTTPHandler(w http.ResponseWriter, r *http.Request, _ httprout
 		return
 	}
 
-	if err := processManager.Services.Update(payload.Name); err != nil {
+	if err := processManager.Services.Update(payload.Name, git.TriggerHTTP); err != nil {
 		getLogger(r).Error(err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 }
 
+func rollbackServiceHTTPHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	payload := new(rollbackHTTPRequest)
+	if err := json.NewDecoder(r.Body).Decode(payload); err != nil {
+		getLogger(r).Error(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := processManager.Services.Rollback(payload.Name, payload.Version, git.TriggerHTTP); err != nil {
+		getLogger(r).Error(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+func rollbackDaemonHTTPHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	payload := new(rollbackHTTPRequest)
+	if err := json.NewDecoder(r.Body).Decode(payload); err != nil {
+		getLogger(r).Error(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := daemonStore.Rollback(payload.Name, payload.Version, git.TriggerHTTP); err != nil {
+		getLogger(r).Error(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+func serviceOfflineUpdateHTTPHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	name := ps.ByName("name")
+	if _, ok := processManager.Services.Find(name); !ok {
+		http.Error(w, fmt.Sprintf("services: service %q not found", name), http.StatusNotFound)
+		return
+	}
+
+	path, err := receiveOfflineUpdate(w, r)
+	if err != nil {
+		getLogger(r).Error(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer os.RemoveAll(filepath.Dir(path))
+
+	checksum := r.URL.Query().Get("sha256")
+	if err := processManager.Services.UpdateFromFile(name, path, checksum, git.TriggerHTTP); err != nil {
+		getLogger(r).Error(err)
+		http.Error(w, err.Error(), offlineUpdateStatus(err))
+		return
+	}
+}
+
+func daemonOfflineUpdateHTTPHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	name := ps.ByName("name")
+	if _, ok := daemonStore.Find(name); !ok {
+		http.Error(w, fmt.Sprintf("daemons: daemon %q not found", name), http.StatusNotFound)
+		return
+	}
+
+	path, err := receiveOfflineUpdate(w, r)
+	if err != nil {
+		getLogger(r).Error(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer os.RemoveAll(filepath.Dir(path))
+
+	checksum := r.URL.Query().Get("sha256")
+	if err := daemonStore.UpdateFromFile(name, path, checksum, git.TriggerHTTP); err != nil {
+		getLogger(r).Error(err)
+		http.Error(w, err.Error(), offlineUpdateStatus(err))
+		return
+	}
+}
+
+// offlineUpdateStatus returns the http status of an offline update error.
+func offlineUpdateStatus(err error) int {
+	if err == git.ErrUntrustedUpload {
+		return http.StatusForbidden
+	}
+
+	return http.StatusInternalServerError
+}
+
 func restartServiceHTTPHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 	payload := new(manageServiceHTTPRequest)
 	if err := json.NewDecoder(r.Body).Decode(payload); err != nil {
@@ -246,6 +366,156 @@ func restartServiceHTTPHandler(w http.ResponseWriter, r *http.Request, _ httprou
 	}
 }
 
+func clearServiceHTTPHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	payload := new(manageServiceHTTPRequest)
+	if err := json.NewDecoder(r.Body).Decode(payload); err != nil {
+		getLogger(r).Error(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := processManager.Services.ClearService(payload.Name); err != nil {
+		getLogger(r).Error(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+func serviceLogsHTTPHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	w.Header().Set("Content-Type", "application/json")
+	name := ps.ByName("name")
+
+	lines := defaultServiceLogsLines
+	if l := r.URL.Query().Get("lines"); len(l) > 0 {
+		var err error
+		lines, err = strconv.Atoi(l)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	logs, err := processManager.Services.Logs(name, lines)
+	if err != nil {
+		getLogger(r).Error(err)
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	payload := struct {
+		Data serviceLogsResponse `json:"data"`
+	}{Data: serviceLogsResponse{Name: name, Lines: logs}}
+	if err := json.NewEncoder(w).Encode(payload); err != nil {
+		getLogger(r).Error(err)
+	}
+}
+
+func serviceHistoryHTTPHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	w.Header().Set("Content-Type", "application/json")
+	name := ps.ByName("name")
+
+	history, err := processManager.Services.History(name)
+	if err != nil {
+		getLogger(r).Error(err)
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	payload := struct {
+		Data serviceHistoryResponse `json:"data"`
+	}{Data: serviceHistoryResponse{Name: name, History: history}}
+	if err := json.NewEncoder(w).Encode(payload); err != nil {
+		getLogger(r).Error(err)
+	}
+}
+
+func serviceDeploymentsHTTPHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	w.Header().Set("Content-Type", "application/json")
+	name := ps.ByName("name")
+
+	deployments, err := processManager.Services.Deployments(name)
+	if err != nil {
+		getLogger(r).Error(err)
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	payload := struct {
+		Data deploymentsResponse `json:"data"`
+	}{Data: deploymentsResponse{Name: name, Deployments: deployments}}
+	if err := json.NewEncoder(w).Encode(payload); err != nil {
+		getLogger(r).Error(err)
+	}
+}
+
+func serviceMetricsHTTPHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	w.Header().Set("Content-Type", "application/json")
+	name := ps.ByName("name")
+
+	metrics, err := processManager.Services.Metrics(name)
+	if err != nil {
+		getLogger(r).Error(err)
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	payload := struct {
+		Data metricsResponse `json:"data"`
+	}{Data: metricsResponse{Name: name, Samples: metrics.Samples()}}
+	if err := json.NewEncoder(w).Encode(payload); err != nil {
+		getLogger(r).Error(err)
+	}
+}
+
+func serviceHeartbeatHTTPHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	name := ps.ByName("name")
+
+	if err := processManager.Services.Heartbeat(name); err != nil {
+		getLogger(r).Error(err)
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func daemonMetricsHTTPHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	w.Header().Set("Content-Type", "application/json")
+	name := ps.ByName("name")
+
+	metrics, err := daemonStore.Metrics(name)
+	if err != nil {
+		getLogger(r).Error(err)
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	payload := struct {
+		Data metricsResponse `json:"data"`
+	}{Data: metricsResponse{Name: name, Samples: metrics.Samples()}}
+	if err := json.NewEncoder(w).Encode(payload); err != nil {
+		getLogger(r).Error(err)
+	}
+}
+
+func daemonDeploymentsHTTPHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	w.Header().Set("Content-Type", "application/json")
+	name := ps.ByName("name")
+
+	deployments, err := daemonStore.Deployments(name)
+	if err != nil {
+		getLogger(r).Error(err)
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	payload := struct {
+		Data deploymentsResponse `json:"data"`
+	}{Data: deploymentsResponse{Name: name, Deployments: deployments}}
+	if err := json.NewEncoder(w).Encode(payload); err != nil {
+		getLogger(r).Error(err)
+	}
+}
+
 func getNetworksHTTPHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 	w.Header().Set("Content-Type", "application/json")
 	networks, err := rasp.AvailableNetworks()
@@ -286,8 +556,7 @@ func updateHTTPHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Para
 		return
 	}
 
-	//TODO: implement support for daemon without a code's repository
-	exec.Command("sudo", "systemctl", "restart", "operator").Run()
+	restartOperator()
 
 	return
 }
@@ -295,19 +564,40 @@ func updateHTTPHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Para
 func restartHTTPHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 	processManager.Stop()
 
-	//TODO: implement support for daemon without a code's repository
-	exec.Command("sudo", "systemctl", "restart", "operator").Run()
+	restartOperator()
 	return
 }
 
+func reloadUnitsHTTPHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	if err := reloadUnits(); err != nil {
+		getLogger(r).Error(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
 // NewHTTPServer returns an initialized server with the following routes:
 //
 // GET /healthz
 // POST /service-start
 // POST /service-stop
 // POST /service-restart
+// POST /service-update
+// POST /service-rollback
+// POST /service-clear
+// GET /services/:name/logs?lines=100
+// GET /services/:name/history
+// GET /services/:name/deployments
+// GET /services/:name/metrics
+// POST /services/:name/heartbeat
+// POST /services/:name/offline-update?sha256=<hex>&filename=<name>
+// POST /daemon-rollback
+// GET /daemons/:name/metrics
+// GET /daemons/:name/deployments
+// POST /daemons/:name/offline-update?sha256=<hex>&filename=<name>
 // POST /update
 // POST /restart
+// POST /reload
 //
 func NewHTTPServer() *http.Server {
 	router := httprouter.New()
@@ -317,8 +607,21 @@ func NewHTTPServer() *http.Server {
 	router.POST("/service-stop", stopServiceHTTPHandler)
 	router.POST("/service-restart", restartServiceHTTPHandler)
 	router.POST("/service-update", updateServiceHTTPHandler)
+	router.POST("/service-rollback", rollbackServiceHTTPHandler)
+	router.POST("/service-clear", clearServiceHTTPHandler)
+	router.GET("/services/:name/logs", serviceLogsHTTPHandler)
+	router.GET("/services/:name/history", serviceHistoryHTTPHandler)
+	router.GET("/services/:name/deployments", serviceDeploymentsHTTPHandler)
+	router.GET("/services/:name/metrics", serviceMetricsHTTPHandler)
+	router.POST("/services/:name/heartbeat", serviceHeartbeatHTTPHandler)
+	router.POST("/services/:name/offline-update", serviceOfflineUpdateHTTPHandler)
+	router.POST("/daemon-rollback", rollbackDaemonHTTPHandler)
+	router.GET("/daemons/:name/metrics", daemonMetricsHTTPHandler)
+	router.GET("/daemons/:name/deployments", daemonDeploymentsHTTPHandler)
+	router.POST("/daemons/:name/offline-update", daemonOfflineUpdateHTTPHandler)
 	router.POST("/update", updateHTTPHandler)
 	router.POST("/restart", restartHTTPHandler)
+	router.POST("/reload", reloadUnitsHTTPHandler)
 
 	addr := fmt.Sprintf(":%d", httpPort)
 	routes := negroni.Wrap(router)
@@ -0,0 +1,403 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/WiseGrowth/go-wisebot/logger"
+	"github.com/WiseGrowth/wisebot-operator/command"
+	"github.com/WiseGrowth/wisebot-operator/cron"
+	"github.com/WiseGrowth/wisebot-operator/git"
+)
+
+// jobRun represents the result of a single job run.
+type jobRun struct {
+	Status     command.Status `json:"status"`
+	StartedAt  time.Time      `json:"started_at"`
+	FinishedAt time.Time      `json:"finished_at"`
+	Duration   string         `json:"duration"`
+	ExitCode   *int           `json:"exit_code"`
+	ExitSignal string         `json:"exit_signal,omitempty"`
+	Error      string         `json:"error,omitempty"`
+}
+
+// Job encapsulates a command run on a schedule or once at boot, and its
+// repository if any. Every run uses a clone of the job command, so the runs
+// share the output file, the output tail and the privileges.
+type Job struct {
+	Name     string
+	cmd      *command.Command
+	repo     *git.Repo      // nil if the job has no code's repository
+	logFile  io.Closer      // command output file
+	schedule *cron.Schedule // nil if the job only runs on boot
+	onBoot   bool
+	timeout  time.Duration // 0 means no timeout
+	env      *serviceEnv   // nil if the job inherits the operator environment
+
+	running *command.Command // command of the run in progress
+	lastRun *jobRun
+	nextRun time.Time
+	timer   *time.Timer // next scheduled run
+	stopped bool        // true if the job must not be scheduled
+
+	mu sync.RWMutex // guards running, lastRun, nextRun, timer and stopped.
+
+	sync.Mutex // guards Bootstrap function.
+}
+
+// MarshalJSON implements json marshal interface
+func (j *Job) MarshalJSON() ([]byte, error) {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+
+	status := command.StatusIdle
+	switch {
+	case j.running != nil:
+		status = command.StatusRunning
+	case j.lastRun != nil:
+		status = j.lastRun.Status
+	}
+
+	var schedule string
+	if j.schedule != nil {
+		schedule = j.schedule.String()
+	}
+
+	var timeout string
+	if j.timeout > 0 {
+		timeout = j.timeout.String()
+	}
+
+	return json.Marshal(struct {
+		Name        string         `json:"name"`
+		Version     string         `json:"version"`
+		Status      command.Status `json:"status"`
+		RepoVersion string         `json:"repo_version"`
+		RepoRef     *git.RefState  `json:"repo_ref,omitempty"`
+		Schedule    string         `json:"schedule,omitempty"`
+		OnBoot      bool           `json:"on_boot"`
+		Timeout     string         `json:"timeout,omitempty"`
+		NextRun     *time.Time     `json:"next_run"`
+		LastRun     *jobRun        `json:"last_run"`
+	}{
+		Name:        j.Name,
+		Version:     j.cmd.Version,
+		Status:      status,
+		RepoVersion: j.repoVersion(),
+		RepoRef:     j.repoRef(),
+		Schedule:    schedule,
+		OnBoot:      j.onBoot,
+		Timeout:     timeout,
+		NextRun:     timeOrNil(j.nextRun),
+		LastRun:     j.lastRun,
+	})
+}
+
+// repoVersion returns the repo current head, or an empty string if the job
+// has no code's repository.
+func (j *Job) repoVersion() string {
+	if j.repo == nil {
+		return ""
+	}
+
+	return j.repo.CurrentHead()
+}
+
+// repoRef returns the ref the repo tracks, or nil if the job has no code's
+// repository.
+func (j *Job) repoRef() *git.RefState {
+	if j.repo == nil {
+		return nil
+	}
+
+	ref := j.repo.CurrentRef()
+	return &ref
+}
+
+func (j *Job) logger() *logrus.Entry {
+	return logger.GetLogger().WithFields(logrus.Fields{
+		"job":          j.Name,
+		"repo_version": j.repoVersion(),
+	})
+}
+
+// Bootstrap proxies function to the its repo. Jobs without a code's
+// repository have nothing to bootstrap.
+func (j *Job) Bootstrap(update bool) error {
+	if j.repo == nil {
+		return nil
+	}
+
+	j.Lock()
+	defer j.Unlock()
+
+	if err := j.repo.Bootstrap(update); err != nil {
+		return err
+	}
+
+	j.cmd.Version = j.repo.CurrentHead()
+
+	return nil
+}
+
+// Start schedules the next job run. If boot is true and the job runs on boot,
+// it's also run in background.
+func (j *Job) Start(boot bool) {
+	j.mu.Lock()
+	j.stopped = false
+	j.mu.Unlock()
+
+	if boot && j.onBoot {
+		go j.runLogged()
+	}
+
+	j.scheduleNext()
+}
+
+// Stop cancels the next job run and stops the run in progress, if any.
+func (j *Job) Stop() error {
+	j.mu.Lock()
+	j.stopped = true
+	if j.timer != nil {
+		j.timer.Stop()
+		j.timer = nil
+	}
+	j.nextRun = time.Time{}
+	cmd := j.running
+	j.mu.Unlock()
+
+	if cmd == nil {
+		return nil
+	}
+
+	return cmd.Stop()
+}
+
+// scheduleNext sets a timer for the next time matching the job schedule. The
+// run after it is scheduled before running the job, so a slow run does not
+// delay the schedule.
+func (j *Job) scheduleNext() {
+	if j.schedule == nil {
+		return
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.stopped {
+		return
+	}
+
+	next := j.schedule.Next(time.Now())
+	if next.IsZero() {
+		j.logger().WithField("schedule", j.schedule.String()).Warn("Job schedule never matches, not scheduled")
+		return
+	}
+
+	j.nextRun = next
+	j.timer = time.AfterFunc(time.Until(next), func() {
+		j.scheduleNext()
+		j.runLogged()
+	})
+}
+
+// runLogged runs the job and logs its error, if any.
+func (j *Job) runLogged() {
+	if err := j.Run(); err != nil {
+		j.logger().Error(err)
+	}
+}
+
+// Run runs the job and waits for it to exit. If the run does not exit within
+// the job timeout, it's stopped. The run result is reported in the job json,
+// and a job-failed event is published if it did not succeed. A job can't run
+// twice at the same time.
+func (j *Job) Run() error {
+	j.mu.Lock()
+	if j.running != nil {
+		j.mu.Unlock()
+		return fmt.Errorf("jobs: job %q is already running", j.Name)
+	}
+
+	cmd := j.cmd.Clone()
+	finished := make(chan error, 1)
+	cmd.Finish = finished
+	j.running = cmd
+	j.mu.Unlock()
+
+	log := j.logger()
+	run := &jobRun{StartedAt: time.Now()}
+
+	var err error
+	if j.env != nil {
+		var env []string
+		env, err = j.env.resolve()
+		if err != nil {
+			cmd.SetStatus(command.StatusBootingError)
+			return j.finish(cmd, run, err)
+		}
+		cmd.SetEnv(env)
+	}
+
+	log.Info("Running job")
+	if err = cmd.Start(); err != nil {
+		return j.finish(cmd, run, err)
+	}
+
+	var timeout <-chan time.Time
+	if j.timeout > 0 {
+		t := time.NewTimer(j.timeout)
+		defer t.Stop()
+		timeout = t.C
+	}
+
+	select {
+	case err = <-finished:
+	case <-timeout:
+		log.WithField("timeout", j.timeout.String()).Warn("Job timed out, stopping")
+		if stopErr := cmd.Stop(); stopErr != nil {
+			log.Error(stopErr)
+		}
+		err = <-finished
+
+		// the run did not finish on its own, so it's a failed run even if
+		// the command exited gracefully. It may have exited right before
+		// being stopped though.
+		if cmd.Status() == command.StatusStopped {
+			run.Status = command.StatusCrashed
+			err = fmt.Errorf("jobs: job %q timed out after %s", j.Name, j.timeout)
+		}
+	}
+
+	return j.finish(cmd, run, err)
+}
+
+// finish records the run result as the job last run. The run status defaults
+// to the command one. It returns the run error unless the run was stopped on
+// purpose.
+func (j *Job) finish(cmd *command.Command, run *jobRun, err error) error {
+	run.FinishedAt = time.Now()
+	run.Duration = run.FinishedAt.Sub(run.StartedAt).String()
+	if len(run.Status) == 0 {
+		run.Status = cmd.Status()
+	}
+
+	code, signal := cmd.ExitState()
+	run.ExitCode = intOrNil(code)
+	if signal != nil {
+		run.ExitSignal = signal.String()
+	}
+
+	if run.Status == command.StatusStopped {
+		err = nil
+	}
+	if err != nil {
+		run.Error = err.Error()
+	}
+
+	j.mu.Lock()
+	j.running = nil
+	j.lastRun = run
+	j.mu.Unlock()
+
+	log := j.logger().WithFields(logrus.Fields{
+		"status":   run.Status,
+		"duration": run.Duration,
+	})
+	switch run.Status {
+	case command.StatusDone, command.StatusStopped:
+		log.Info("Job finished")
+	default:
+		log.Warn("Job failed")
+		go publishEvent(eventJobFailed, j)
+	}
+
+	return err
+}
+
+// JobStore represents a set of jobs.
+type JobStore struct {
+	mu   sync.RWMutex
+	list map[string]*Job
+}
+
+// MarshalJSON implements json marshal interface
+func (js *JobStore) MarshalJSON() ([]byte, error) {
+	return json.Marshal(js.List())
+}
+
+// Find looks the job in the list by its name. If the job does not exists, it
+// returns a nil Job and a false value.
+func (js *JobStore) Find(name string) (job *Job, ok bool) {
+	js.mu.RLock()
+	defer js.mu.RUnlock()
+
+	job, ok = js.list[name]
+	return job, ok
+}
+
+// List returns the jobs in the store.
+func (js *JobStore) List() []*Job {
+	js.mu.RLock()
+	defer js.mu.RUnlock()
+
+	jobs := make([]*Job, 0, len(js.list))
+	for _, job := range js.list {
+		jobs = append(jobs, job)
+	}
+
+	return jobs
+}
+
+// Save adds the job to the list.
+func (js *JobStore) Save(job *Job) {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+
+	if js.list == nil {
+		js.list = make(map[string]*Job)
+	}
+	js.list[job.Name] = job
+}
+
+// Remove stops the job, closes its output file and deletes it from the list.
+func (js *JobStore) Remove(name string) {
+	js.mu.Lock()
+	job, ok := js.list[name]
+	delete(js.list, name)
+	js.mu.Unlock()
+
+	if !ok {
+		return
+	}
+
+	if err := job.Stop(); err != nil {
+		job.logger().Error(err)
+	}
+
+	if job.logFile != nil {
+		job.logFile.Close()
+	}
+}
+
+// Start schedules every job in the list. The jobs that run on boot are also
+// run in background.
+func (js *JobStore) Start() {
+	for _, job := range js.List() {
+		job.Start(true)
+	}
+}
+
+// Stop cancels the scheduled runs and stops the runs in progress.
+func (js *JobStore) Stop() {
+	for _, job := range js.List() {
+		if err := job.Stop(); err != nil {
+			job.logger().Error(err)
+		}
+	}
+}
@@ -0,0 +1,210 @@
+package logfile
+
+/*
+This package provides a file writer that rotates the file when it reaches a
+max size, keeping a limited number of rotated files that can be optionally
+compressed with gzip.
+*/
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const gzipExt = ".gz"
+
+// Writer is an io.WriteCloser that appends to the file located in Path. When
+// the file reaches MaxSize bytes it is renamed to Path.1, the previous Path.1
+// to Path.2 and so on, keeping MaxFiles rotated files. Rotated files are
+// compressed in background, the ones that can't be compressed are kept as is.
+type Writer struct {
+	Path     string
+	MaxSize  int64
+	MaxFiles int
+	Compress bool
+
+	mu   sync.Mutex // guards file and size
+	file *os.File
+	size int64
+
+	compressing sync.WaitGroup // rotated file being compressed
+}
+
+// New creates the file directory if it does not exists and opens the file in
+// append mode.
+func New(path string, maxSize int64, maxFiles int, compress bool) (*Writer, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+
+	w := &Writer{
+		Path:     path,
+		MaxSize:  maxSize,
+		MaxFiles: maxFiles,
+		Compress: compress,
+	}
+
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+
+	return w, nil
+}
+
+// Write implements the io.Writer interface. The file is rotated before writing
+// if the given bytes don't fit in it.
+func (w *Writer) Write(p []byte) (n int, err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file == nil {
+		return 0, os.ErrClosed
+	}
+
+	if w.size > 0 && w.size+int64(len(p)) > w.MaxSize {
+		if err := w.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err = w.file.Write(p)
+	w.size += int64(n)
+
+	return n, err
+}
+
+// Close implements the io.Closer interface.
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.compressing.Wait()
+
+	if w.file == nil {
+		return nil
+	}
+
+	err := w.file.Close()
+	w.file = nil
+
+	return err
+}
+
+func (w *Writer) open() error {
+	file, err := os.OpenFile(w.Path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	w.file = file
+	w.size = info.Size()
+
+	return nil
+}
+
+// rotate closes the current file, shifts the rotated files and opens a new
+// one. Rotated files beyond MaxFiles are removed. The file is opened again
+// even if the rotation fails, so the writer keeps appending to Path.
+func (w *Writer) rotate() (err error) {
+	defer func() {
+		if openErr := w.open(); err == nil {
+			err = openErr
+		}
+	}()
+
+	err = w.file.Close()
+	w.file = nil
+	if err != nil {
+		return err
+	}
+
+	if w.MaxFiles < 1 {
+		if err := os.Remove(w.Path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+
+	// the previous rotated file must be compressed before being shifted.
+	w.compressing.Wait()
+
+	w.removeRotated(w.MaxFiles)
+	for i := w.MaxFiles - 1; i > 0; i-- {
+		for _, ext := range []string{"", gzipExt} {
+			err := os.Rename(w.rotatedPath(i)+ext, w.rotatedPath(i+1)+ext)
+			if err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+
+	if err := os.Rename(w.Path, w.rotatedPath(1)); err != nil {
+		return err
+	}
+
+	if w.Compress {
+		path := w.rotatedPath(1)
+		w.compressing.Add(1)
+		go func() {
+			defer w.compressing.Done()
+			compress(path)
+		}()
+	}
+
+	return nil
+}
+
+func (w *Writer) rotatedPath(i int) string {
+	return fmt.Sprintf("%s.%d", w.Path, i)
+}
+
+func (w *Writer) removeRotated(i int) {
+	os.Remove(w.rotatedPath(i))
+	os.Remove(w.rotatedPath(i) + gzipExt)
+}
+
+// compress gzips the file located in path into path.gz and removes the
+// original file. If it fails, the original file is kept.
+func compress(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(path+gzipExt, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	gz := gzip.NewWriter(dst)
+	if _, err := io.Copy(gz, src); err != nil {
+		gz.Close()
+		dst.Close()
+		os.Remove(path + gzipExt)
+		return err
+	}
+
+	if err := gz.Close(); err != nil {
+		dst.Close()
+		os.Remove(path + gzipExt)
+		return err
+	}
+
+	if err := dst.Close(); err != nil {
+		os.Remove(path + gzipExt)
+		return err
+	}
+
+	return os.Remove(path)
+}
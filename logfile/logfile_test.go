@@ -0,0 +1,185 @@
+package logfile
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestWriterRotation(t *testing.T) {
+	// every line is 7 bytes long, so files with a max size of 15 bytes hold
+	// two lines.
+	tests := []struct {
+		name     string
+		maxSize  int64
+		maxFiles int
+		compress bool
+		lines    int
+		want     map[string]string
+	}{
+		{
+			"no rotation",
+			100, 2, false, 3,
+			map[string]string{
+				"x.log": "line 1\nline 2\nline 3\n",
+			},
+		},
+		{
+			"rotated files are shifted",
+			15, 3, false, 5,
+			map[string]string{
+				"x.log":   "line 5\n",
+				"x.log.1": "line 3\nline 4\n",
+				"x.log.2": "line 1\nline 2\n",
+			},
+		},
+		{
+			"oldest rotated files are removed",
+			15, 2, false, 7,
+			map[string]string{
+				"x.log":   "line 7\n",
+				"x.log.1": "line 5\nline 6\n",
+				"x.log.2": "line 3\nline 4\n",
+			},
+		},
+		{
+			"no rotated files",
+			15, 0, false, 7,
+			map[string]string{
+				"x.log": "line 7\n",
+			},
+		},
+		{
+			"compressed rotated files",
+			15, 3, true, 7,
+			map[string]string{
+				"x.log":      "line 7\n",
+				"x.log.1.gz": "line 5\nline 6\n",
+				"x.log.2.gz": "line 3\nline 4\n",
+				"x.log.3.gz": "line 1\nline 2\n",
+			},
+		},
+		{
+			"lines bigger than the max size",
+			5, 2, false, 3,
+			map[string]string{
+				"x.log":   "line 3\n",
+				"x.log.1": "line 2\n",
+				"x.log.2": "line 1\n",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		dir := tempDir(t)
+		defer os.RemoveAll(dir)
+
+		w, err := New(filepath.Join(dir, "logs", "x.log"), tt.maxSize, tt.maxFiles, tt.compress)
+		if err != nil {
+			t.Fatalf("%s: New: %s", tt.name, err)
+		}
+
+		for i := 1; i <= tt.lines; i++ {
+			if _, err := fmt.Fprintf(w, "line %d\n", i); err != nil {
+				t.Fatalf("%s: Write: %s", tt.name, err)
+			}
+		}
+
+		if err := w.Close(); err != nil {
+			t.Fatalf("%s: Close: %s", tt.name, err)
+		}
+
+		if got := readFiles(t, filepath.Join(dir, "logs")); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got files %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestWriterAppends(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "x.log")
+	if err := ioutil.WriteFile(path, []byte("line 1\nline 2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := New(path, 15, 1, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the existing file is full, so the first write rotates it.
+	if _, err := w.Write([]byte("line 3\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{
+		"x.log":   "line 3\n",
+		"x.log.1": "line 1\nline 2\n",
+	}
+	if got := readFiles(t, dir); !reflect.DeepEqual(got, want) {
+		t.Errorf("got files %q, want %q", got, want)
+	}
+
+	if _, err := w.Write([]byte("line 4\n")); err != os.ErrClosed {
+		t.Errorf("Write after Close: got %v, want %v", err, os.ErrClosed)
+	}
+}
+
+func tempDir(t *testing.T) string {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "logfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return dir
+}
+
+// readFiles returns the contents of the files in dir by name, gzipped files
+// are decompressed.
+func readFiles(t *testing.T, dir string) map[string]string {
+	t.Helper()
+
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := make(map[string]string)
+	for _, info := range infos {
+		f, err := os.Open(filepath.Join(dir, info.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var data []byte
+		if strings.HasSuffix(info.Name(), gzipExt) {
+			gz, err := gzip.NewReader(f)
+			if err != nil {
+				t.Fatalf("%s: %s", info.Name(), err)
+			}
+			data, err = ioutil.ReadAll(gz)
+		} else {
+			data, err = ioutil.ReadAll(f)
+		}
+		f.Close()
+		if err != nil {
+			t.Fatalf("%s: %s", info.Name(), err)
+		}
+
+		files[info.Name()] = string(data)
+	}
+
+	return files
+}
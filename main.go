@@ -8,290 +8,81 @@ import (
 	"os"
 	"os/exec"
 	"os/signal"
-	"runtime"
 	"syscall"
 	"time"
 
 	"github.com/WiseGrowth/go-wisebot/config"
 	"github.com/WiseGrowth/go-wisebot/logger"
 	"github.com/WiseGrowth/go-wisebot/rasp"
-	"github.com/WiseGrowth/wisebot-operator/command"
 	"github.com/WiseGrowth/wisebot-operator/daemon"
-	"github.com/WiseGrowth/wisebot-operator/git"
 	"github.com/WiseGrowth/wisebot-operator/iot"
-	homedir "github.com/mitchellh/go-homedir"
+	"github.com/WiseGrowth/wisebot-operator/systemd"
 )
 
 var (
 	operatorVersion string
 
-	wisebotCoreRepoBranchName                  string
-	wisebotBleRepoBranchName                   string
-	wisebotScriptRepoBranchName                string
-	wisebotButtonDaemonRepoBranchName          string
-	wisebotStorageRepoBranchName               string
-	wisebotLedDaemonRepoBranchName             string
-	wisebotTunnelDaemonRepoBranchName          string
-	wisebotNetworkOperatorDaemonRepoBranchName string
-
-	version                                      string
-	baseURL                                      string
-	wisebotCoreRepoExpandedPath                  string
-	wisebotBleRepoExpandedPath                   string
-	wisebotScriptRepoExpandedPath                string
-	wisebotButtonDaemonRepoExpandedPath          string
-	wisebotLedDaemonRepoExpandedPath             string
-	wisebotTunnelDaemonRepoExpandedPath          string
-	wisebotStorageRepoExpandedPath               string
-	wisebotNetworkOperatorDaemonRepoExpandedPath string
+	version string
+	baseURL string
 
 	wisebotConfig *config.Config
 	wisebotLogger io.WriteCloser
 
 	healthzPublishableTopic string
+	eventsPublishableTopic  string
 
 	httpServer     *http.Server
 	processManager *ProcessManager
 	daemonStore    *daemon.Store
+	unitLoader     *UnitLoader
 )
 
 const (
-	wisebotCoreServiceName = "wisebot-core"
-	wisebotCoreRepoPath    = "~/wisebot-core"
-	wisebotCoreRepoRemote  = "git@github.com:wisegrowth/wisebot-core.git"
-
-	wisebotBleServiceName = "wisebot-ble"
-	wisebotBleRepoPath    = "~/wisebot-ble"
-	wisebotBleRepoRemote  = "git@github.com:wisegrowth/wisebot-ble.git"
-
-	wisebotScriptServiceName = "wisebot-script"
-	wisebotScriptRepoPath    = "~/wisebot-script"
-	wisebotScriptRepoRemote  = "git@github.com:wisegrowth/wisebot-script.git"
-
-	wisebotButtonDaemonName       = "wisebot-button"
-	wisebotButtonDaemonRepoPath   = "~/wisebot-button"
-	wisebotButtonDaemonRepoRemote = "git@github.com:wisegrowth/wisebot-button.git"
-
-	wisebotNetworkOperatorDaemonName       = "network-operator"
-	wisebotNetworkOperatorDaemonRepoPath   = "~/network-operator"
-	wisebotNetworkOperatorDaemonRepoRemote = "git@github.com:wisegrowth/network-operator.git"
-
-	wisebotLedDaemonName       = "led"
-	wisebotLedDaemonRepoPath   = "~/wisebot-led-indicator"
-	wisebotLedDaemonRepoRemote = "git@github.com:wisegrowth/wisebot-led-indicator.git"
-
-	wisebotSSHTunnelDaemonName     = "ssh-tunnel"
-	wisebotStorageTunnelDaemonName = "storage-tunnel"
-	wisebotTunnelDaemonRepoPath    = "~/wisebot-tunnel"
-	wisebotTunnelDaemonRepoRemote  = "git@github.com:wisegrowth/wisebot-tunnel.git"
-
-	wisebotStorageServiceName = "wisebot-storage"
-	wisebotStorageRepoPath    = "~/wisebot-storage"
-	wisebotStorageRepoRemote  = "git@github.com:wisegrowth/wisebot-storage.git"
-
-	//TODO: should implement support to use NewDaemon without code's repository
-
 	wisebotConfigPath = "~/.config/wisebot/config.json"
 	wisebotLogPath    = "~/.wisebot/logs/operator.log"
 
-	defaultBranchName = "master"
+	// operatorDaemonName is the operator's own systemd service name.
+	operatorDaemonName = "operator"
 )
 
-func init() {
+// setup loads the wisebot config and opens the operator log file. It is not an
+// init function so the package tests run without a wisebot config.
+func setup() {
 	var err error
 
 	// ----- Load wisebot config
-	wisebotConfig, err = config.LoadConfig(wisebotConfigPath)
+	configPath, err := expandHome(wisebotConfigPath)
+	check(err)
+	wisebotConfig, err = config.LoadConfig(configPath)
 	check(err)
 
 	processManager = new(ProcessManager)
 	daemonStore = new(daemon.Store)
 
-	wisebotCoreRepoExpandedPath, err = homedir.Expand(wisebotCoreRepoPath)
-	check(err)
-
-	wisebotBleRepoExpandedPath, err = homedir.Expand(wisebotBleRepoPath)
-	check(err)
-
-	wisebotNetworkOperatorDaemonRepoExpandedPath, err = homedir.Expand(wisebotNetworkOperatorDaemonRepoPath)
-	check(err)
-
-	wisebotLedDaemonRepoExpandedPath, err = homedir.Expand(wisebotLedDaemonRepoPath)
-	check(err)
-
-	wisebotScriptRepoExpandedPath, err = homedir.Expand(wisebotScriptRepoPath)
-	check(err)
-
-	wisebotButtonDaemonRepoExpandedPath, err = homedir.Expand(wisebotButtonDaemonRepoPath)
-	check(err)
-
-	wisebotTunnelDaemonRepoExpandedPath, err = homedir.Expand(wisebotTunnelDaemonRepoPath)
-	check(err)
-
-	wisebotStorageRepoExpandedPath, err = homedir.Expand(wisebotStorageRepoPath)
-	check(err)
-
 	healthzPublishableTopic = fmt.Sprintf("/operator/%s/healthz", wisebotConfig.WisebotID)
+	eventsPublishableTopic = fmt.Sprintf("/operator/%s/events", wisebotConfig.WisebotID)
 
 	wisebotLogger, err = newFile(wisebotLogPath)
 	check(err)
 }
 
 func main() {
+	setup()
 	defer wisebotLogger.Close()
 	check(logger.Init(wisebotLogger, wisebotConfig.WisebotID, wisebotConfig.SentryDSN))
 
-	// ----- Validate existence of repositories branch names
-	if len(wisebotConfig.ScriptBranch) == 0 {
-		wisebotScriptRepoBranchName = defaultBranchName
-	} else {
-		wisebotScriptRepoBranchName = wisebotConfig.ScriptBranch
-	}
-
-	if len(wisebotConfig.NetworkOperatorBranch) == 0 {
-		wisebotNetworkOperatorDaemonRepoBranchName = defaultBranchName
-	} else {
-		wisebotNetworkOperatorDaemonRepoBranchName = wisebotConfig.NetworkOperatorBranch
-	}
-
-	if len(wisebotConfig.LedBranch) == 0 {
-		wisebotLedDaemonRepoBranchName = defaultBranchName
-	} else {
-		wisebotLedDaemonRepoBranchName = wisebotConfig.LedBranch
-	}
-
-	if len(wisebotConfig.CoreBranch) == 0 {
-		wisebotCoreRepoBranchName = defaultBranchName
-	} else {
-		wisebotCoreRepoBranchName = wisebotConfig.CoreBranch
-	}
-
-	if len(wisebotConfig.BleBranch) == 0 {
-		wisebotBleRepoBranchName = defaultBranchName
-	} else {
-		wisebotBleRepoBranchName = wisebotConfig.BleBranch
-	}
-
-	if len(wisebotConfig.ButtonBranch) == 0 {
-		wisebotButtonDaemonRepoBranchName = defaultBranchName
-	} else {
-		wisebotButtonDaemonRepoBranchName = wisebotConfig.ButtonBranch
-	}
-
-	if len(wisebotConfig.TunnelBranch) == 0 {
-		wisebotTunnelDaemonRepoBranchName = defaultBranchName
-	} else {
-		wisebotTunnelDaemonRepoBranchName = wisebotConfig.TunnelBranch
-	}
-
-	if len(wisebotConfig.StorageBranch) == 0 {
-		wisebotStorageRepoBranchName = defaultBranchName
-	} else {
-		wisebotStorageRepoBranchName = wisebotConfig.StorageBranch
-	}
-
 	log := logger.GetLogger().WithField("version", version)
 	log.Info("Starting")
 
-	// ----- Initialize git repos
-	scriptRepo := git.NewRepo(
-		wisebotScriptRepoExpandedPath,
-		wisebotScriptRepoRemote,
-		wisebotScriptRepoBranchName,
-	)
-
-	networkOperatorDaemonRepo := git.NewRepo(
-		wisebotNetworkOperatorDaemonRepoExpandedPath,
-		wisebotNetworkOperatorDaemonRepoRemote,
-		wisebotNetworkOperatorDaemonRepoBranchName,
-	)
-
-	ledDaemonRepo := git.NewRepo(
-		wisebotLedDaemonRepoExpandedPath,
-		wisebotLedDaemonRepoRemote,
-		wisebotLedDaemonRepoBranchName,
-		git.YarnInstallHook,
-	)
-
-	tunnelDaemonRepo := git.NewRepo(
-		wisebotTunnelDaemonRepoExpandedPath,
-		wisebotTunnelDaemonRepoRemote,
-		wisebotTunnelDaemonRepoBranchName,
-		git.YarnInstallHook,
-	)
-
-	coreRepo := git.NewRepo(
-		wisebotCoreRepoExpandedPath,
-		wisebotCoreRepoRemote,
-		wisebotCoreRepoBranchName,
-		git.YarnInstallHook,
-	)
-
-	bleRepo := git.NewRepo(
-		wisebotBleRepoExpandedPath,
-		wisebotBleRepoRemote,
-		wisebotBleRepoBranchName,
-		git.NpmInstallHook,
-	)
-
-	buttonDaemonRepo := git.NewRepo(
-		wisebotButtonDaemonRepoExpandedPath,
-		wisebotButtonDaemonRepoRemote,
-		wisebotButtonDaemonRepoBranchName,
-	)
-
-	storageRepo := git.NewRepo(
-		wisebotStorageRepoExpandedPath,
-		wisebotStorageRepoRemote,
-		wisebotStorageRepoBranchName,
-	)
-
-	// ----- Initialize daemons
-	if runtime.GOOS != "darwin" {
-		d, err := daemon.NewDaemon(wisebotNetworkOperatorDaemonName, networkOperatorDaemonRepo)
-		check(err)
-		daemonStore.Save(d)
-
-		d, err = daemon.NewDaemon(wisebotLedDaemonName, ledDaemonRepo)
-		check(err)
-		daemonStore.Save(d)
-
-		d, err = daemon.NewDaemon(wisebotSSHTunnelDaemonName, tunnelDaemonRepo)
-		check(err)
-		daemonStore.Save(d)
-
-		d, err = daemon.NewDaemon(wisebotStorageTunnelDaemonName, tunnelDaemonRepo)
-		check(err)
-		daemonStore.Save(d)
-
-		d, err = daemon.NewDaemon(wisebotButtonDaemonName, buttonDaemonRepo)
-		check(err)
-		daemonStore.Save(d)
-	}
-
-	// ----- Initialize commands
-	wisebotCoreCommand := command.NewCommand(
-		"node",
-		wisebotCoreRepoExpandedPath+"/build/app/index.js",
-	)
-
-	wisebotBleCommand := command.NewCommand(
-		"node",
-		wisebotBleRepoExpandedPath+"/build/app/index.js",
-	)
-
-	wisebotScriptCommand := command.NewCommand(
-		"sudo",
-		wisebotScriptRepoExpandedPath+"/wisebot-script",
-	)
-
-	// wisebotButtonCommand := command.NewCommand(
-	// 	wisebotButtonRepoExpandedPath + "/wisebot-button",
-	// )
+	// ----- Load units manifest
+	units, err := loadManifest()
+	check(err)
 
-	wisebotStorageCommand := command.NewCommand(
-		wisebotStorageRepoExpandedPath + "/wisebot-storage",
-	)
+	services := new(ServiceStore)
+	unitLoader = &UnitLoader{Services: services, Daemons: daemonStore, Jobs: new(JobStore)}
+	check(unitLoader.Load(units))
+	go sampleMetrics(services, daemonStore)
+	go watchOfflineUpdates(services, daemonStore)
 
 	// ----- Initialize MQTT client
 	cert, err := wisebotConfig.GetTLSCertificate()
@@ -312,17 +103,10 @@ func main() {
 	isConnected, err := rasp.IsConnected()
 	check(err)
 
-	// ----- Append services to global store
-	services := new(ServiceStore)
-	services.Save(wisebotCoreServiceName, wisebotCoreCommand, coreRepo)
-	services.Save(wisebotBleServiceName, wisebotBleCommand, bleRepo)
-	services.Save(wisebotScriptServiceName, wisebotScriptCommand, scriptRepo)
-	// services.Save(wisebotButtonServiceName, wisebotButtonCommand, buttonRepo)
-	services.Save(wisebotStorageServiceName, wisebotStorageCommand, storageRepo)
-
 	processManager = &ProcessManager{
 		MQTTClient: mqttClient,
 		Services:   services,
+		Units:      unitLoader,
 	}
 
 	httpServer = NewHTTPServer()
@@ -337,7 +121,6 @@ func main() {
 	log.Debug(fmt.Sprintf("Internet connection: %v", isConnected))
 	updateSourceCode := isConnected
 	check(processManager.KickOffServices(updateSourceCode))
-	check(daemonStore.Bootstrap(updateSourceCode))
 	if isConnected {
 		check(processManager.KickOffMQTTClient())
 	} else {
@@ -357,6 +140,7 @@ func main() {
 		}()
 	}
 	listenInterrupt(quit)
+	listenReload()
 	<-quit
 	gracefullShutdown()
 	wisebotLogger.Close()
@@ -373,6 +157,22 @@ func listenInterrupt(quit chan struct{}) {
 	}()
 }
 
+// listenReload reloads the units manifest every time the operator receives a
+// SIGHUP signal.
+func listenReload() {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGHUP)
+	go func() {
+		for s := range c {
+			log := logger.GetLogger().WithField("signal", s.String())
+			log.Debug("Signal received")
+			if err := reloadUnits(); err != nil {
+				log.Error(err)
+			}
+		}
+	}()
+}
+
 func gracefullShutdown() {
 	log := logger.GetLogger()
 	log.Debug("Gracefully shutdown")
@@ -382,6 +182,13 @@ func gracefullShutdown() {
 	processManager.Stop()
 }
 
+// restartOperator tells systemd to restart the operator daemon.
+func restartOperator() {
+	if err := systemd.Restart(operatorDaemonName); err != nil {
+		logger.GetLogger().Error(err)
+	}
+}
+
 func check(err error) {
 	if err != nil {
 		log := logger.GetLogger()
@@ -0,0 +1,659 @@
+package manifest
+
+/*
+This package describes the units (services, daemons and jobs) that the operator
+manages. Units are declared in a json file so new processes can be added to a
+device without building a new operator.
+*/
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/WiseGrowth/wisebot-operator/cron"
+	"github.com/WiseGrowth/wisebot-operator/git"
+	"github.com/WiseGrowth/wisebot-operator/watchdog"
+	homedir "github.com/mitchellh/go-homedir"
+)
+
+// Kind represents the unit kind.
+type Kind string
+
+// Unit kinds
+const (
+	KindService Kind = "service"
+	KindDaemon  Kind = "daemon"
+	KindJob     Kind = "job"
+)
+
+// Manifest represents the list of units the operator manages.
+type Manifest struct {
+	Units []Unit `json:"units"`
+}
+
+// Unit represents a single service, daemon or job definition.
+//
+// Services are processes started and supervised by the operator, so they must
+// declare the executable to run. Daemons are systemd services, and the unit
+// name must match the systemd service name. Daemons can omit the repo if they
+// don't have a code's repository, eg: filebeat. Jobs are commands run by the
+// operator on a schedule or once at boot, and they can also omit the repo.
+type Unit struct {
+	Name string `json:"name"`
+	Kind Kind   `json:"kind"`
+	Repo *Repo  `json:"repo,omitempty"`
+
+	Exec      string           `json:"exec,omitempty"`
+	Args      []string         `json:"args,omitempty"`
+	Restart   *RestartPolicy   `json:"restart,omitempty"`
+	CrashLoop *CrashLoopPolicy `json:"crash_loop,omitempty"`
+	Log       *LogPolicy       `json:"log,omitempty"`
+	// StopTimeout is how long the operator waits for the service to exit after
+	// each stop signal, before escalating to SIGTERM and SIGKILL.
+	StopTimeout Duration   `json:"stop_timeout,omitempty"`
+	Probes      *Probes    `json:"probes,omitempty"`
+	Watchdog    *Watchdog  `json:"watchdog,omitempty"`
+	Resources   *Resources `json:"resources,omitempty"`
+	// UpdateProbation is how long an updated service must keep running and
+	// healthy, or the update is rolled back. 0 disables the probation.
+	UpdateProbation Duration `json:"update_probation,omitempty"`
+
+	// User and Group are the user and primary group the service runs as, by
+	// name or id. Group defaults to the user primary group. Groups are the
+	// supplementary groups, the user ones if omitted, and Capabilities the
+	// linux capabilities granted to the service, eg: CAP_NET_ADMIN.
+	User         string   `json:"user,omitempty"`
+	Group        string   `json:"group,omitempty"`
+	Groups       []string `json:"groups,omitempty"`
+	Capabilities []string `json:"capabilities,omitempty"`
+
+	// Env is merged over the EnvFiles variables, and the env files are merged
+	// in order. If CleanEnv is true, the service does not inherit the operator
+	// environment. WorkingDir defaults to the repo path.
+	Env        map[string]string `json:"env,omitempty"`
+	EnvFiles   []string          `json:"env_files,omitempty"`
+	CleanEnv   bool              `json:"clean_env,omitempty"`
+	WorkingDir string            `json:"working_dir,omitempty"`
+
+	// PreStart hooks run before starting the service, and it's not started if
+	// any of them fails. PostStart hooks run after starting it and PostStop
+	// hooks after it exits.
+	PreStart  []Hook `json:"pre_start,omitempty"`
+	PostStart []Hook `json:"post_start,omitempty"`
+	PostStop  []Hook `json:"post_stop,omitempty"`
+
+	// Schedule is the cron schedule of a job, eg: "0 3 * * *". If OnBoot is
+	// true, the job also runs once when the operator starts. Timeout is how
+	// long a job run can take before being stopped, 0 means no timeout.
+	Schedule string   `json:"schedule,omitempty"`
+	OnBoot   bool     `json:"on_boot,omitempty"`
+	Timeout  Duration `json:"timeout,omitempty"`
+
+	// After lists the units the service is started after. Requires also lists
+	// units the service is started after, but the service is blocked if any of
+	// them is not up. Daemons can only depend on daemons, which just orders
+	// their bootstrap.
+	After    []string `json:"after,omitempty"`
+	Requires []string `json:"requires,omitempty"`
+}
+
+// Probes represents the service health checks. When the liveness probe fails,
+// the service is restarted. The readiness probe is only reported.
+type Probes struct {
+	Liveness  *Probe `json:"liveness,omitempty"`
+	Readiness *Probe `json:"readiness,omitempty"`
+}
+
+// Probe represents a single health check. Exactly one of HTTP (an url), TCP
+// (an address) or Exec (a command and its args) must be set.
+type Probe struct {
+	HTTP string   `json:"http,omitempty"`
+	TCP  string   `json:"tcp,omitempty"`
+	Exec []string `json:"exec,omitempty"`
+
+	InitialDelay     Duration `json:"initial_delay,omitempty"`
+	Interval         Duration `json:"interval,omitempty"`
+	Timeout          Duration `json:"timeout,omitempty"`
+	FailureThreshold int      `json:"failure_threshold,omitempty"`
+}
+
+// Watchdog represents the service heartbeat. The service must send a heartbeat
+// at least every Interval, by touching a file (file), writing to a unix
+// datagram socket (socket) or doing a POST request to the operator (http), or
+// it's restarted. InitialDelay gives the service time to boot before the first
+// heartbeat.
+type Watchdog struct {
+	Kind         string   `json:"kind"`
+	Interval     Duration `json:"interval"`
+	InitialDelay Duration `json:"initial_delay,omitempty"`
+}
+
+// Resources represents the cgroup limits of a service. Zero values mean no
+// limit. CPUWeight is relative to other services, between 1 and 10000, and
+// CPUQuota is a percentage of a single cpu, eg: 50.
+type Resources struct {
+	MemoryMaxMB int `json:"memory_max_mb,omitempty"`
+	CPUWeight   int `json:"cpu_weight,omitempty"`
+	CPUQuota    int `json:"cpu_quota,omitempty"`
+	PidsMax     int `json:"pids_max,omitempty"`
+}
+
+// Hook represents a command, and its args, run around the service lifecycle.
+// Timeout defaults to 30s.
+type Hook struct {
+	Exec    []string `json:"exec"`
+	Timeout Duration `json:"timeout,omitempty"`
+}
+
+// Restart policies
+const (
+	RestartNever     = "never"
+	RestartOnFailure = "on-failure"
+	RestartAlways    = "always"
+)
+
+// RestartPolicy represents when and how often a crashed service is restarted.
+// The delay between restarts starts at Backoff and it is doubled on every
+// retry until MaxBackoff. If the service runs longer than ResetAfter, the
+// retries count is reset.
+type RestartPolicy struct {
+	Policy string `json:"policy"`
+	// MaxRetries is the max number of consecutive restarts, 0 means unlimited.
+	MaxRetries int      `json:"max_retries,omitempty"`
+	Backoff    Duration `json:"backoff,omitempty"`
+	MaxBackoff Duration `json:"max_backoff,omitempty"`
+	ResetAfter Duration `json:"reset_after,omitempty"`
+}
+
+// CrashLoopPolicy represents when a service is considered in a crash-loop: if
+// it exits MaxExits times within Window, it's quarantined and won't be
+// restarted until someone clears it.
+type CrashLoopPolicy struct {
+	MaxExits int      `json:"max_exits,omitempty"`
+	Window   Duration `json:"window,omitempty"`
+}
+
+// LogPolicy represents how the service output file is rotated. The file is
+// rotated when it reaches MaxSizeMB megabytes, and MaxFiles rotated files are
+// kept, optionally compressed with gzip. TailLines is the number of output
+// lines kept in memory.
+type LogPolicy struct {
+	MaxSizeMB int  `json:"max_size_mb,omitempty"`
+	MaxFiles  int  `json:"max_files,omitempty"`
+	Compress  bool `json:"compress,omitempty"`
+	TailLines int  `json:"tail_lines,omitempty"`
+}
+
+// Duration is a time.Duration that is represented in json as a string, eg: 10s.
+type Duration struct {
+	time.Duration
+}
+
+// UnmarshalJSON implements the json unmarshal interface
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+
+	duration, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+
+	d.Duration = duration
+	return nil
+}
+
+// MarshalJSON implements the json marshal interface
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+// Repo represents the unit code's repository. The source is the tip of
+// Branch, a Tag, the highest tag matching the Version semver range, eg: ^1.2.0,
+// or a full Commit sha. Only one of them can be set, and the source defaults to
+// the master branch. If Releases is set, every revision is checked out into its
+// own release directory and that many releases are kept, instead of updating
+// the working tree in place.
+type Repo struct {
+	Path    string `json:"path"`
+	Remote  string `json:"remote"`
+	Branch  string `json:"branch,omitempty"`
+	Tag     string `json:"tag,omitempty"`
+	Version string `json:"version,omitempty"`
+	Commit  string `json:"commit,omitempty"`
+	// Releases is the number of releases kept, including the current one.
+	Releases int `json:"releases,omitempty"`
+	// TrustedKeys are the keys that must sign the deployed revisions.
+	TrustedKeys *TrustedKeys `json:"trusted_keys,omitempty"`
+	// Hooks are the post-receive hooks, run in order after every checkout.
+	Hooks []RepoHook `json:"hooks,omitempty"`
+}
+
+// RepoHook represents a post-receive hook. It's declared as a preset name, eg:
+// "yarn-install", or as a command run in the repo work tree, eg:
+// {"exec": ["make", "build"]}. WorkingDir is relative to the work tree, and
+// Timeout defaults to 10m. If Paths is set, the command only runs when the
+// revision checked out changes a file matching one of the path patterns.
+type RepoHook struct {
+	Preset     string            `json:"-"`
+	Exec       []string          `json:"exec"`
+	Env        map[string]string `json:"env,omitempty"`
+	WorkingDir string            `json:"working_dir,omitempty"`
+	Timeout    Duration          `json:"timeout,omitempty"`
+	User       string            `json:"user,omitempty"`
+	Paths      []string          `json:"paths,omitempty"`
+}
+
+type rawRepoHook RepoHook
+
+// UnmarshalJSON implements the json unmarshal interface
+func (h *RepoHook) UnmarshalJSON(b []byte) error {
+	if err := json.Unmarshal(b, &h.Preset); err == nil {
+		return nil
+	}
+
+	// the manifest decoder does not disallow unknown fields of custom types.
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	return dec.Decode((*rawRepoHook)(h))
+}
+
+// MarshalJSON implements the json marshal interface
+func (h RepoHook) MarshalJSON() ([]byte, error) {
+	if len(h.Preset) > 0 {
+		return json.Marshal(h.Preset)
+	}
+
+	return json.Marshal(rawRepoHook(h))
+}
+
+// TrustedKeys represents the keys trusted to sign the repo revisions: a GnuPG
+// home directory with the trusted public keys imported, and/or an ssh allowed
+// signers file. Commits are verified, or the tags if the repo is pinned to a
+// tag or a semver range.
+type TrustedKeys struct {
+	GPGHome           string `json:"gpg_home,omitempty"`
+	SSHAllowedSigners string `json:"ssh_allowed_signers,omitempty"`
+}
+
+// Ref returns the repo pinned source. It returns false if the repo tracks a
+// branch.
+func (r *Repo) Ref() (ref git.Ref, ok bool) {
+	switch {
+	case len(r.Tag) > 0:
+		return git.Ref{Kind: git.RefTag, Name: r.Tag}, true
+	case len(r.Version) > 0:
+		return git.Ref{Kind: git.RefSemver, Name: r.Version}, true
+	case len(r.Commit) > 0:
+		return git.Ref{Kind: git.RefCommit, Name: r.Commit}, true
+	}
+
+	return ref, false
+}
+
+// Load reads and validates the manifest located at the given path. If the file
+// does not exist, the returned error satisfies os.IsNotExist.
+func Load(path string) (*Manifest, error) {
+	expanded, err := homedir.Expand(path)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(expanded)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	m := new(Manifest)
+	dec := json.NewDecoder(f)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(m); err != nil {
+		return nil, fmt.Errorf("manifest: invalid file %q: %s", expanded, err.Error())
+	}
+
+	if err := m.Validate(); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// Validate checks that every unit is well defined, that unit names are unique
+// and that the dependencies between units exist and have no cycles.
+func (m *Manifest) Validate() error {
+	names := make(map[string]bool, len(m.Units))
+
+	for _, u := range m.Units {
+		if len(u.Name) == 0 {
+			return fmt.Errorf("manifest: unit without name")
+		}
+
+		if names[u.Name] {
+			return fmt.Errorf("manifest: unit %q is declared more than once", u.Name)
+		}
+		names[u.Name] = true
+
+		if err := u.validate(); err != nil {
+			return err
+		}
+	}
+
+	for _, u := range m.Units {
+		for _, dep := range u.Dependencies() {
+			if dep == u.Name {
+				return fmt.Errorf("manifest: unit %q depends on itself", u.Name)
+			}
+
+			d, ok := m.Find(dep)
+			if !ok {
+				return fmt.Errorf("manifest: unit %q depends on unknown unit %q", u.Name, dep)
+			}
+
+			if d.Kind == KindJob {
+				return fmt.Errorf("manifest: unit %q depends on job %q, only services and daemons can be required", u.Name, dep)
+			}
+
+			// daemons are bootstrapped before any service.
+			if u.Kind == KindDaemon && d.Kind != KindDaemon {
+				return fmt.Errorf("manifest: daemon %q depends on %s %q, daemons can only depend on daemons", u.Name, d.Kind, dep)
+			}
+		}
+	}
+
+	_, err := m.Order()
+	return err
+}
+
+// Order returns the units sorted so every unit comes after the units it
+// depends on. Units that don't depend on each other keep the manifest order.
+// It returns an error if the dependencies have a cycle.
+func (m *Manifest) Order() ([]Unit, error) {
+	const (
+		visiting = iota + 1
+		visited
+	)
+
+	state := make(map[string]int, len(m.Units))
+	order := make([]Unit, 0, len(m.Units))
+
+	// path contains the units being visited, so it holds the cycle when a
+	// unit is visited twice.
+	var path []string
+	var visit func(u Unit) error
+	visit = func(u Unit) error {
+		switch state[u.Name] {
+		case visited:
+			return nil
+		case visiting:
+			for i, name := range path {
+				if name == u.Name {
+					cycle := append(path[i:len(path):len(path)], u.Name)
+					return fmt.Errorf("manifest: dependency cycle between units %s", strings.Join(cycle, " -> "))
+				}
+			}
+		}
+
+		state[u.Name] = visiting
+		path = append(path, u.Name)
+		for _, dep := range u.Dependencies() {
+			d, ok := m.Find(dep)
+			if !ok {
+				continue
+			}
+
+			if err := visit(d); err != nil {
+				return err
+			}
+		}
+		path = path[:len(path)-1]
+		state[u.Name] = visited
+		order = append(order, u)
+
+		return nil
+	}
+
+	for _, u := range m.Units {
+		if err := visit(u); err != nil {
+			return nil, err
+		}
+	}
+
+	return order, nil
+}
+
+// Dependencies returns the units the unit is started after, both the ordering
+// only and the required ones.
+func (u *Unit) Dependencies() []string {
+	deps := make([]string, 0, len(u.After)+len(u.Requires))
+	deps = append(deps, u.After...)
+	return append(deps, u.Requires...)
+}
+
+// Find looks the unit in the manifest by its name.
+func (m *Manifest) Find(name string) (u Unit, ok bool) {
+	for _, u := range m.Units {
+		if u.Name == name {
+			return u, true
+		}
+	}
+
+	return u, false
+}
+
+func (p *Probe) valid() bool {
+	checks := 0
+	if len(p.HTTP) > 0 {
+		checks++
+	}
+	if len(p.TCP) > 0 {
+		checks++
+	}
+	if len(p.Exec) > 0 {
+		checks++
+	}
+
+	return checks == 1
+}
+
+// runsCommand returns true if the operator runs the unit command, instead of
+// systemd.
+func (u *Unit) runsCommand() bool {
+	return u.Kind == KindService || u.Kind == KindJob
+}
+
+func (u *Unit) validate() error {
+	switch u.Kind {
+	case KindService:
+		if len(u.Exec) == 0 {
+			return fmt.Errorf("manifest: service %q has no exec", u.Name)
+		}
+
+		if u.Repo == nil {
+			return fmt.Errorf("manifest: service %q has no repo", u.Name)
+		}
+	case KindDaemon:
+		// daemons can run without a code's repository.
+	case KindJob:
+		if len(u.Exec) == 0 {
+			return fmt.Errorf("manifest: job %q has no exec", u.Name)
+		}
+
+		if len(u.Schedule) == 0 && !u.OnBoot {
+			return fmt.Errorf("manifest: job %q has no schedule and does not run on boot", u.Name)
+		}
+
+		if len(u.Schedule) > 0 {
+			if _, err := cron.Parse(u.Schedule); err != nil {
+				return fmt.Errorf("manifest: job %q: %s", u.Name, err.Error())
+			}
+		}
+
+		if u.Timeout.Duration < 0 {
+			return fmt.Errorf("manifest: job %q has a negative timeout", u.Name)
+		}
+	default:
+		return fmt.Errorf("manifest: unit %q has unknown kind %q", u.Name, u.Kind)
+	}
+
+	if u.Repo != nil {
+		if len(u.Repo.Path) == 0 || len(u.Repo.Remote) == 0 {
+			return fmt.Errorf("manifest: unit %q repo must have path and remote", u.Name)
+		}
+
+		sources := 0
+		for _, s := range []string{u.Repo.Branch, u.Repo.Tag, u.Repo.Version, u.Repo.Commit} {
+			if len(s) > 0 {
+				sources++
+			}
+		}
+		if sources > 1 {
+			return fmt.Errorf("manifest: unit %q repo must have only one of branch, tag, version or commit", u.Name)
+		}
+
+		if k := u.Repo.TrustedKeys; k != nil && len(k.GPGHome) == 0 && len(k.SSHAllowedSigners) == 0 {
+			return fmt.Errorf("manifest: unit %q repo trusted keys must have a gpg home or ssh allowed signers", u.Name)
+		}
+
+		if u.Repo.Releases < 0 {
+			return fmt.Errorf("manifest: unit %q repo has a negative number of releases", u.Name)
+		}
+
+		for _, h := range u.Repo.Hooks {
+			if len(h.Preset) == 0 && len(h.Exec) == 0 {
+				return fmt.Errorf("manifest: unit %q has a post-receive hook without exec", u.Name)
+			}
+
+			if h.Timeout.Duration < 0 {
+				return fmt.Errorf("manifest: unit %q post-receive hook has a negative timeout", u.Name)
+			}
+
+			for _, p := range h.Paths {
+				if _, err := path.Match(p, ""); err != nil {
+					return fmt.Errorf("manifest: unit %q post-receive hook has an invalid path %q", u.Name, p)
+				}
+			}
+		}
+
+		if ref, ok := u.Repo.Ref(); ok {
+			if err := ref.Validate(); err != nil {
+				return fmt.Errorf("manifest: unit %q repo: %s", u.Name, err.Error())
+			}
+		}
+	}
+
+	if u.CrashLoop != nil && u.Kind != KindService {
+		return fmt.Errorf("manifest: unit %q crash-loop policy is only supported by services", u.Name)
+	}
+
+	if u.Log != nil && !u.runsCommand() {
+		return fmt.Errorf("manifest: unit %q log policy is only supported by services and jobs", u.Name)
+	}
+
+	if u.StopTimeout.Duration != 0 && !u.runsCommand() {
+		return fmt.Errorf("manifest: unit %q stop timeout is only supported by services and jobs", u.Name)
+	}
+
+	if (len(u.Schedule) > 0 || u.OnBoot || u.Timeout.Duration != 0) && u.Kind != KindJob {
+		return fmt.Errorf("manifest: unit %q schedule, on boot and timeout are only supported by jobs", u.Name)
+	}
+
+	if u.UpdateProbation.Duration != 0 {
+		if u.Kind != KindService {
+			return fmt.Errorf("manifest: unit %q update probation is only supported by services", u.Name)
+		}
+
+		if u.UpdateProbation.Duration < 0 {
+			return fmt.Errorf("manifest: unit %q has a negative update probation", u.Name)
+		}
+	}
+
+	if u.Probes != nil {
+		if u.Kind != KindService {
+			return fmt.Errorf("manifest: unit %q probes are only supported by services", u.Name)
+		}
+
+		for name, p := range map[string]*Probe{"liveness": u.Probes.Liveness, "readiness": u.Probes.Readiness} {
+			if p != nil && !p.valid() {
+				return fmt.Errorf("manifest: unit %q %s probe must have exactly one of http, tcp or exec", u.Name, name)
+			}
+		}
+	}
+
+	if w := u.Watchdog; w != nil {
+		if u.Kind != KindService {
+			return fmt.Errorf("manifest: unit %q watchdog is only supported by services", u.Name)
+		}
+
+		switch w.Kind {
+		case watchdog.KindFile, watchdog.KindSocket, watchdog.KindHTTP:
+		default:
+			return fmt.Errorf("manifest: unit %q has unknown watchdog kind %q", u.Name, w.Kind)
+		}
+
+		if w.Interval.Duration <= 0 || w.InitialDelay.Duration < 0 {
+			return fmt.Errorf("manifest: unit %q watchdog must have a positive interval", u.Name)
+		}
+	}
+
+	if len(u.User) > 0 || len(u.Group) > 0 || len(u.Groups) > 0 || len(u.Capabilities) > 0 {
+		if !u.runsCommand() {
+			return fmt.Errorf("manifest: unit %q user, groups and capabilities are only supported by services and jobs", u.Name)
+		}
+
+		if len(u.User) == 0 && (len(u.Group) > 0 || len(u.Groups) > 0) {
+			return fmt.Errorf("manifest: unit %q declares groups without user", u.Name)
+		}
+	}
+
+	if (len(u.Env) > 0 || len(u.EnvFiles) > 0 || u.CleanEnv || len(u.WorkingDir) > 0) && !u.runsCommand() {
+		return fmt.Errorf("manifest: unit %q environment and working dir are only supported by services and jobs", u.Name)
+	}
+
+	if (len(u.After) > 0 || len(u.Requires) > 0) && u.Kind == KindJob {
+		return fmt.Errorf("manifest: unit %q dependencies are only supported by services and daemons", u.Name)
+	}
+
+	for stage, hooks := range map[string][]Hook{"pre_start": u.PreStart, "post_start": u.PostStart, "post_stop": u.PostStop} {
+		if len(hooks) > 0 && u.Kind != KindService {
+			return fmt.Errorf("manifest: unit %q hooks are only supported by services", u.Name)
+		}
+
+		for _, h := range hooks {
+			if len(h.Exec) == 0 {
+				return fmt.Errorf("manifest: unit %q has a %s hook without exec", u.Name, stage)
+			}
+		}
+	}
+
+	if r := u.Resources; r != nil {
+		if u.Kind != KindService {
+			return fmt.Errorf("manifest: unit %q resources are only supported by services", u.Name)
+		}
+
+		if r.MemoryMaxMB < 0 || r.CPUQuota < 0 || r.PidsMax < 0 || r.CPUWeight < 0 || r.CPUWeight > 10000 {
+			return fmt.Errorf("manifest: unit %q has invalid resources", u.Name)
+		}
+	}
+
+	if u.Restart != nil {
+		if u.Kind != KindService {
+			return fmt.Errorf("manifest: unit %q restart policy is only supported by services", u.Name)
+		}
+
+		switch u.Restart.Policy {
+		case RestartNever, RestartOnFailure, RestartAlways:
+		default:
+			return fmt.Errorf("manifest: unit %q has unknown restart policy %q", u.Name, u.Restart.Policy)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,243 @@
+package manifest
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// service is a valid service unit, the tests add the fields they check.
+const service = `"kind": "service", "exec": "node", "repo": {"path": "~/core", "remote": "git@github.com:wisegrowth/core.git"}`
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name     string
+		manifest string
+		err      string // empty if valid
+	}{
+		{
+			"service",
+			`{"units": [{"name": "core", ` + service + `}]}`,
+			"",
+		},
+		{
+			"daemon without repo",
+			`{"units": [{"name": "filebeat", "kind": "daemon"}]}`,
+			"",
+		},
+		{
+			"job",
+			`{"units": [{"name": "backup", "kind": "job", "exec": "backup", "schedule": "0 3 * * *"}]}`,
+			"",
+		},
+		{
+			"unit without name",
+			`{"units": [{` + service + `}]}`,
+			"unit without name",
+		},
+		{
+			"duplicated unit",
+			`{"units": [{"name": "core", ` + service + `}, {"name": "core", "kind": "daemon"}]}`,
+			`"core" is declared more than once`,
+		},
+		{
+			"unknown kind",
+			`{"units": [{"name": "core", "kind": "timer"}]}`,
+			`unknown kind "timer"`,
+		},
+		{
+			"service without exec",
+			`{"units": [{"name": "core", "kind": "service", "repo": {"path": "~/core", "remote": "r"}}]}`,
+			"has no exec",
+		},
+		{
+			"service without repo",
+			`{"units": [{"name": "core", "kind": "service", "exec": "node"}]}`,
+			"has no repo",
+		},
+		{
+			"repo without remote",
+			`{"units": [{"name": "led", "kind": "daemon", "repo": {"path": "~/led"}}]}`,
+			"must have path and remote",
+		},
+		{
+			"repo with more than one source",
+			`{"units": [{"name": "led", "kind": "daemon", "repo": {"path": "~/led", "remote": "r", "branch": "master", "tag": "v1.0.0"}}]}`,
+			"only one of branch, tag, version or commit",
+		},
+		{
+			"repo with an invalid version range",
+			`{"units": [{"name": "led", "kind": "daemon", "repo": {"path": "~/led", "remote": "r", "version": "latest"}}]}`,
+			`unit "led" repo`,
+		},
+		{
+			"job without schedule",
+			`{"units": [{"name": "backup", "kind": "job", "exec": "backup"}]}`,
+			"no schedule and does not run on boot",
+		},
+		{
+			"job with an invalid schedule",
+			`{"units": [{"name": "backup", "kind": "job", "exec": "backup", "schedule": "0 25 * * *"}]}`,
+			"hour",
+		},
+		{
+			"unknown restart policy",
+			`{"units": [{"name": "core", ` + service + `, "restart": {"policy": "sometimes"}}]}`,
+			`unknown restart policy "sometimes"`,
+		},
+		{
+			"daemon restart policy",
+			`{"units": [{"name": "led", "kind": "daemon", "restart": {"policy": "always"}}]}`,
+			"only supported by services",
+		},
+		{
+			"probe with two checks",
+			`{"units": [{"name": "core", ` + service + `, "probes": {"liveness": {"http": "http://localhost", "tcp": ":80"}}}]}`,
+			"probe",
+		},
+		{
+			"groups without user",
+			`{"units": [{"name": "core", ` + service + `, "groups": ["gpio"]}]}`,
+			"declares groups without user",
+		},
+		{
+			"daemon user",
+			`{"units": [{"name": "led", "kind": "daemon", "user": "pi"}]}`,
+			"only supported by services and jobs",
+		},
+		{
+			"invalid resources",
+			`{"units": [{"name": "core", ` + service + `, "resources": {"cpu_weight": 10001}}]}`,
+			"invalid resources",
+		},
+		{
+			"hook without exec",
+			`{"units": [{"name": "core", ` + service + `, "pre_start": [{"exec": []}]}]}`,
+			"pre_start hook without exec",
+		},
+		{
+			"service after a daemon",
+			`{"units": [{"name": "core", ` + service + `, "after": ["network"]}, {"name": "network", "kind": "daemon"}]}`,
+			"",
+		},
+		{
+			"daemon after a daemon",
+			`{"units": [{"name": "led", "kind": "daemon", "requires": ["network"]}, {"name": "network", "kind": "daemon"}]}`,
+			"",
+		},
+		{
+			"daemon after a service",
+			`{"units": [{"name": "led", "kind": "daemon", "after": ["core"]}, {"name": "core", ` + service + `}]}`,
+			"daemons can only depend on daemons",
+		},
+		{
+			"job dependencies",
+			`{"units": [{"name": "backup", "kind": "job", "exec": "backup", "on_boot": true, "after": ["core"]}, {"name": "core", ` + service + `}]}`,
+			"only supported by services and daemons",
+		},
+		{
+			"dependency on a job",
+			`{"units": [{"name": "core", ` + service + `, "after": ["backup"]}, {"name": "backup", "kind": "job", "exec": "backup", "on_boot": true}]}`,
+			`depends on job "backup"`,
+		},
+		{
+			"unknown dependency",
+			`{"units": [{"name": "core", ` + service + `, "requires": ["storage"]}]}`,
+			`depends on unknown unit "storage"`,
+		},
+		{
+			"dependency on itself",
+			`{"units": [{"name": "core", ` + service + `, "after": ["core"]}]}`,
+			`"core" depends on itself`,
+		},
+		{
+			"dependency cycle",
+			`{"units": [{"name": "a", ` + service + `, "after": ["b"]}, {"name": "b", ` + service + `, "requires": ["a"]}]}`,
+			"dependency cycle between units a -> b -> a",
+		},
+	}
+
+	for _, tt := range tests {
+		m := decode(t, tt.manifest)
+
+		err := m.Validate()
+		switch {
+		case len(tt.err) == 0 && err != nil:
+			t.Errorf("%s: unexpected error: %s", tt.name, err)
+		case len(tt.err) > 0 && err == nil:
+			t.Errorf("%s: expected an error containing %q", tt.name, tt.err)
+		case len(tt.err) > 0 && !strings.Contains(err.Error(), tt.err):
+			t.Errorf("%s: got error %q, want it to contain %q", tt.name, err, tt.err)
+		}
+	}
+}
+
+func TestOrder(t *testing.T) {
+	tests := []struct {
+		name     string
+		manifest string
+		want     []string
+	}{
+		{
+			"no dependencies keep the manifest order",
+			`{"units": [{"name": "c", ` + service + `}, {"name": "a", ` + service + `}, {"name": "b", ` + service + `}]}`,
+			[]string{"c", "a", "b"},
+		},
+		{
+			"dependencies come first",
+			`{"units": [{"name": "core", ` + service + `, "after": ["network", "storage"]}, {"name": "storage", ` + service + `, "requires": ["network"]}, {"name": "network", "kind": "daemon"}]}`,
+			[]string{"network", "storage", "core"},
+		},
+		{
+			"shared dependencies are ordered once",
+			`{"units": [{"name": "a", ` + service + `, "after": ["c"]}, {"name": "b", ` + service + `, "after": ["c"]}, {"name": "c", ` + service + `}]}`,
+			[]string{"c", "a", "b"},
+		},
+		{
+			"daemons depend on daemons",
+			`{"units": [{"name": "led", "kind": "daemon", "after": ["network"]}, {"name": "network", "kind": "daemon"}]}`,
+			[]string{"network", "led"},
+		},
+	}
+
+	for _, tt := range tests {
+		m := decode(t, tt.manifest)
+
+		units, err := m.Order()
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", tt.name, err)
+			continue
+		}
+
+		var got []string
+		for _, u := range units {
+			got = append(got, u.Name)
+		}
+
+		if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("%s: got order %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestOrderCycle(t *testing.T) {
+	m := decode(t, `{"units": [{"name": "a", `+service+`, "after": ["b"]}, {"name": "b", `+service+`, "after": ["c"]}, {"name": "c", `+service+`, "after": ["b"]}]}`)
+
+	_, err := m.Order()
+	if err == nil || !strings.Contains(err.Error(), "b -> c -> b") {
+		t.Errorf("got error %v, want a cycle between b and c", err)
+	}
+}
+
+func decode(t *testing.T, s string) *Manifest {
+	t.Helper()
+
+	m := new(Manifest)
+	dec := json.NewDecoder(strings.NewReader(s))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(m); err != nil {
+		t.Fatalf("invalid manifest %s: %s", s, err)
+	}
+
+	return m
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"time"
+
+	"github.com/WiseGrowth/go-wisebot/logger"
+	"github.com/WiseGrowth/wisebot-operator/daemon"
+	"github.com/WiseGrowth/wisebot-operator/procstat"
+)
+
+// metricsInterval is how often the services and daemons resource usage is
+// sampled. Along with procstat.DefaultHistorySize, it keeps the last 10
+// minutes of samples.
+const metricsInterval = 10 * time.Second
+
+// sampleMetrics samples every metricsInterval the resource usage of the
+// running services and daemons. It never returns.
+func sampleMetrics(services *ServiceStore, daemons *daemon.Store) {
+	tick := time.NewTicker(metricsInterval)
+	defer tick.Stop()
+
+	for range tick.C {
+		for _, svc := range services.List() {
+			sampleProcessGroup(svc.Name, svc.command().Pid(), svc.metrics)
+		}
+
+		for _, d := range daemons.List() {
+			pid, err := d.MainPID()
+			if err != nil {
+				d.Logger().WithField("error", err).Debug("Could not get main pid")
+				continue
+			}
+			sampleProcessGroup(d.Name(), pid, d.Metrics())
+		}
+	}
+}
+
+// sampleProcessGroup adds a sample of the pid process group to the history.
+// Processes that are not running are skipped.
+func sampleProcessGroup(name string, pid int, h *procstat.History) {
+	if pid == 0 {
+		return
+	}
+
+	s, err := procstat.ProcessGroup(pid)
+	if err != nil {
+		logger.GetLogger().WithField("name", name).WithField("error", err).Debug("Could not sample process metrics")
+		return
+	}
+
+	h.Add(*s)
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/WiseGrowth/go-wisebot/logger"
+	"github.com/WiseGrowth/wisebot-operator/daemon"
+	"github.com/WiseGrowth/wisebot-operator/git"
+	"github.com/sirupsen/logrus"
+)
+
+// offlineUpdatesGlobs match the directories scanned for offline updates, the
+// `wisebot-updates` directory of the mounted USB sticks.
+var offlineUpdatesGlobs = []string{
+	"/media/*/wisebot-updates",
+	"/media/*/*/wisebot-updates",
+	"/mnt/*/wisebot-updates",
+}
+
+// offlineUpdateExtensions are the extensions of the offline update files, the
+// file name without its extension is the unit name, eg: wisebot-core.bundle.
+// The checksum of a file is read from the same path plus `.sha256`.
+var offlineUpdateExtensions = []string{".bundle", ".tar.gz", ".tgz", ".tar"}
+
+// offlineUpdatesInterval is how often the offline updates directories are
+// scanned.
+const offlineUpdatesInterval = 30 * time.Second
+
+// maxOfflineUpdateSize is the size limit of the offline updates uploaded over
+// http.
+const maxOfflineUpdateSize = 1 << 30
+
+// offlineUpdateStamp identifies a version of an offline update file, so it's
+// only applied once.
+type offlineUpdateStamp struct {
+	size     int64
+	modTime  time.Time
+	checksum string
+}
+
+// watchOfflineUpdates applies every offlineUpdatesInterval the offline update
+// files found in the offlineUpdatesGlobs directories to the services and
+// daemons they are named after, whether there is internet access or not.
+// Files are applied once, failed ones are retried when they change. It never
+// returns.
+func watchOfflineUpdates(services *ServiceStore, daemons *daemon.Store) {
+	applied := make(map[string]offlineUpdateStamp)
+
+	tick := time.NewTicker(offlineUpdatesInterval)
+	defer tick.Stop()
+
+	for range tick.C {
+		for _, path := range findOfflineUpdates() {
+			info, err := os.Stat(path)
+			if err != nil {
+				continue
+			}
+
+			stamp := offlineUpdateStamp{size: info.Size(), modTime: info.ModTime()}
+			if b, err := ioutil.ReadFile(path + ".sha256"); err == nil {
+				stamp.checksum = strings.TrimSpace(string(b))
+			}
+
+			if applied[path] == stamp {
+				continue
+			}
+			applied[path] = stamp
+
+			name := offlineUpdateUnit(path)
+			log := logger.GetLogger().WithFields(logrus.Fields{"unit": name, "file": path})
+			log.Info("Offline update found")
+
+			var updateErr error
+			if _, ok := services.Find(name); ok {
+				updateErr = services.UpdateFromFile(name, path, stamp.checksum, git.TriggerUSB)
+			} else if _, ok := daemons.Find(name); ok {
+				updateErr = daemons.UpdateFromFile(name, path, stamp.checksum, git.TriggerUSB)
+			} else {
+				updateErr = fmt.Errorf("no service or daemon named %q", name)
+			}
+
+			if updateErr != nil {
+				log.WithField("error", updateErr).Error("Could not apply the offline update")
+			}
+		}
+	}
+}
+
+// findOfflineUpdates returns the offline update files in the offline updates
+// directories.
+func findOfflineUpdates() []string {
+	var paths []string
+	for _, glob := range offlineUpdatesGlobs {
+		dirs, _ := filepath.Glob(glob)
+		for _, dir := range dirs {
+			infos, err := ioutil.ReadDir(dir)
+			if err != nil {
+				continue
+			}
+
+			for _, info := range infos {
+				path := filepath.Join(dir, info.Name())
+				if info.Mode().IsRegular() && offlineUpdateUnit(path) != "" {
+					paths = append(paths, path)
+				}
+			}
+		}
+	}
+
+	return paths
+}
+
+// offlineUpdateUnit returns the name of the unit the file updates, or an empty
+// string if it's not an offline update file.
+func offlineUpdateUnit(path string) string {
+	base := filepath.Base(path)
+	for _, ext := range offlineUpdateExtensions {
+		if strings.HasSuffix(base, ext) {
+			return strings.TrimSuffix(base, ext)
+		}
+	}
+
+	return ""
+}
+
+// receiveOfflineUpdate saves the request body into a temporary directory, in a
+// file named after the `filename` query parameter, and returns its path. The
+// caller must remove the directory.
+func receiveOfflineUpdate(w http.ResponseWriter, r *http.Request) (string, error) {
+	name := filepath.Base(r.URL.Query().Get("filename"))
+	if name == "." || name == string(filepath.Separator) {
+		name = "upload"
+	}
+
+	dir, err := ioutil.TempDir("", "wisebot-update")
+	if err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(dir, name)
+	f, err := os.Create(path)
+	if err != nil {
+		os.RemoveAll(dir)
+		return "", err
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(f, http.MaxBytesReader(w, r.Body, maxOfflineUpdateSize)); err != nil {
+		os.RemoveAll(dir)
+		return "", err
+	}
+
+	return path, nil
+}
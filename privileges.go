@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+	"syscall"
+
+	"github.com/WiseGrowth/wisebot-operator/command"
+	"github.com/WiseGrowth/wisebot-operator/manifest"
+)
+
+// newUnitPrivileges resolves the user, groups and capabilities the service or
+// job runs with. It returns nil if it runs as the operator user without extra
+// capabilities.
+func newUnitPrivileges(u manifest.Unit, usr *user.User) (*command.Privileges, error) {
+	if usr == nil && len(u.Capabilities) == 0 {
+		return nil, nil
+	}
+
+	p := new(command.Privileges)
+	for _, name := range u.Capabilities {
+		c, err := command.ParseCapability(name)
+		if err != nil {
+			return nil, fmt.Errorf("units: %s %q: %s", u.Kind, u.Name, err.Error())
+		}
+		p.Capabilities = append(p.Capabilities, c)
+	}
+
+	if usr == nil {
+		return p, nil
+	}
+
+	uid, err := parseID(usr.Uid)
+	if err != nil {
+		return nil, err
+	}
+
+	gid, err := parseID(usr.Gid)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(u.Group) > 0 {
+		gid, err = lookupGroup(u.Group)
+		if err != nil {
+			return nil, fmt.Errorf("units: %s %q: %s", u.Kind, u.Name, err.Error())
+		}
+	}
+
+	// running as the operator user needs no credential, and setting it would
+	// fail if the operator can't change its groups.
+	if int(uid) == os.Getuid() && int(gid) == os.Getgid() && u.Groups == nil {
+		return p, nil
+	}
+
+	// without groups, the unit keeps the user supplementary groups, like a
+	// login would. An empty list drops them.
+	names := u.Groups
+	if names == nil {
+		names, err = usr.GroupIds()
+		if err != nil {
+			return nil, fmt.Errorf("units: %s %q: %s", u.Kind, u.Name, err.Error())
+		}
+	}
+
+	var groups []uint32
+	for _, name := range names {
+		g, err := lookupGroup(name)
+		if err != nil {
+			return nil, fmt.Errorf("units: %s %q: %s", u.Kind, u.Name, err.Error())
+		}
+		groups = append(groups, g)
+	}
+
+	p.Credential = &syscall.Credential{Uid: uid, Gid: gid, Groups: groups}
+	return p, nil
+}
+
+// userCredential returns the credential to run as the user, with its primary
+// and supplementary groups. It returns nil for the operator user.
+func userCredential(usr *user.User) (*syscall.Credential, error) {
+	uid, err := parseID(usr.Uid)
+	if err != nil {
+		return nil, err
+	}
+
+	gid, err := parseID(usr.Gid)
+	if err != nil {
+		return nil, err
+	}
+
+	if int(uid) == os.Getuid() {
+		return nil, nil
+	}
+
+	ids, err := usr.GroupIds()
+	if err != nil {
+		return nil, err
+	}
+
+	var groups []uint32
+	for _, id := range ids {
+		g, err := parseID(id)
+		if err != nil {
+			return nil, err
+		}
+		groups = append(groups, g)
+	}
+
+	return &syscall.Credential{Uid: uid, Gid: gid, Groups: groups}, nil
+}
+
+// lookupUser looks the user up by its name or its id.
+func lookupUser(name string) (*user.User, error) {
+	if _, err := strconv.Atoi(name); err == nil {
+		return user.LookupId(name)
+	}
+
+	return user.Lookup(name)
+}
+
+// lookupGroup looks the group id up by the group name or its id.
+func lookupGroup(name string) (uint32, error) {
+	if id, err := parseID(name); err == nil {
+		return id, nil
+	}
+
+	g, err := user.LookupGroup(name)
+	if err != nil {
+		return 0, err
+	}
+
+	return parseID(g.Gid)
+}
+
+func parseID(id string) (uint32, error) {
+	n, err := strconv.ParseUint(id, 10, 32)
+	if err != nil {
+		return 0, err
+	}
+
+	return uint32(n), nil
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"time"
+
+	"github.com/WiseGrowth/wisebot-operator/git"
+	"github.com/sirupsen/logrus"
+)
+
+// probation represents the window after an update in which the service must
+// keep running and healthy, or the update is rolled back.
+type probation struct {
+	Version         string    `json:"version"`          // updated repo version
+	PreviousVersion string    `json:"previous_version"` // version restored if the update fails
+	EndsAt          time.Time `json:"ends_at"`
+
+	timer *time.Timer
+}
+
+// updateFailedEvent represents the data published when an updated service
+// fails its probation.
+type updateFailedEvent struct {
+	Service       *Service `json:"service"`
+	FailedVersion string   `json:"failed_version"`
+	Reason        string   `json:"reason"`
+	RollbackError string   `json:"rollback_error,omitempty"`
+}
+
+// startProbation puts the updated service on probation, if it declares a
+// probation window. The previous version is the repo version before the
+// update.
+func (s *Service) startProbation(previousVersion string) {
+	if s.updateProbation <= 0 || len(previousVersion) == 0 {
+		return
+	}
+
+	p := &probation{
+		Version:         s.repo.CurrentHead(),
+		PreviousVersion: previousVersion,
+		EndsAt:          time.Now().Add(s.updateProbation),
+	}
+
+	s.mu.Lock()
+	if s.probation != nil {
+		s.probation.timer.Stop()
+	}
+	s.probation = p
+	p.timer = time.AfterFunc(s.updateProbation, func() { s.probationEnded(p) })
+	s.mu.Unlock()
+
+	s.logger().WithField("ends_at", p.EndsAt).Info("Updated service on probation")
+}
+
+// cancelProbation takes the service out of probation without rolling back.
+func (s *Service) cancelProbation() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.probation != nil {
+		s.probation.timer.Stop()
+		s.probation = nil
+	}
+}
+
+// currentProbation returns the service probation, if it's on probation.
+func (s *Service) currentProbation() *probation {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.probation
+}
+
+// probationEnded accepts the update if the service is ready when the window
+// ends. Services with a readiness probe that is still failing are rolled back.
+func (s *Service) probationEnded(p *probation) {
+	if s.currentProbation() != p {
+		return
+	}
+
+	if !s.ready() {
+		s.failProbation("readiness probe not succeeding at the end of the probation")
+		return
+	}
+
+	s.mu.Lock()
+	if s.probation == p {
+		s.probation = nil
+	}
+	s.mu.Unlock()
+
+	s.logger().Info("Updated service passed its probation")
+}
+
+// failProbation rolls back the update through the service store and publishes
+// an update-failed event. It returns false if the service is not on probation,
+// so the caller handles the failure as usual.
+func (s *Service) failProbation(reason string) bool {
+	s.mu.Lock()
+	p := s.probation
+	if p != nil {
+		p.timer.Stop()
+		s.probation = nil
+	}
+	s.mu.Unlock()
+
+	if p == nil {
+		return false
+	}
+
+	log := s.logger().WithFields(logrus.Fields{
+		"reason":           reason,
+		"failed_version":   p.Version,
+		"previous_version": p.PreviousVersion,
+	})
+	log.Warn("Updated service failed its probation, rolling back")
+
+	go func() {
+		event := updateFailedEvent{Service: s, FailedVersion: p.Version, Reason: reason}
+		if s.store != nil {
+			if err := s.store.Rollback(s.Name, p.PreviousVersion, git.TriggerProbation); err != nil {
+				log.Error(err)
+				event.RollbackError = err.Error()
+			}
+		}
+
+		publishEvent(eventUpdateFailed, event)
+	}()
+
+	return true
+}
@@ -0,0 +1,261 @@
+package probe
+
+/*
+This package checks periodically if a service is healthy, by doing an http
+GET request, opening a tcp connection or running a command.
+*/
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os/exec"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Status represents the probe result status
+type Status string
+
+// Probe statuses
+const (
+	StatusUnknown Status = "unknown"
+	StatusSuccess Status = "success"
+	StatusFailure Status = "failure"
+)
+
+// Probe defaults
+const (
+	DefaultInterval         = 10 * time.Second
+	DefaultTimeout          = 1 * time.Second
+	DefaultFailureThreshold = 3
+)
+
+// Checker knows how to check if a service is healthy.
+type Checker interface {
+	Check(ctx context.Context) error
+}
+
+// HTTPChecker checks the service by doing a GET request to the URL. Any status
+// code between 200 and 399 is considered a success.
+type HTTPChecker struct {
+	URL string
+}
+
+// Check implements the Checker interface.
+func (c *HTTPChecker) Check(ctx context.Context) error {
+	req, err := http.NewRequest(http.MethodGet, c.URL, nil)
+	if err != nil {
+		return err
+	}
+
+	res, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode >= 400 {
+		return fmt.Errorf("probe: unexpected status code %d", res.StatusCode)
+	}
+
+	return nil
+}
+
+// TCPChecker checks the service by opening a tcp connection to the address.
+type TCPChecker struct {
+	Address string
+}
+
+// Check implements the Checker interface.
+func (c *TCPChecker) Check(ctx context.Context) error {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", c.Address)
+	if err != nil {
+		return err
+	}
+
+	return conn.Close()
+}
+
+// ExecChecker checks the service by running a command. The command must exit
+// with status 0. It runs in its own process group, which is killed when the
+// check times out, so its children don't outlive it.
+type ExecChecker struct {
+	Name string
+	Args []string
+	// Command builds the process to run, eg: with the service environment
+	// and privileges. If nil, it runs like the operator.
+	Command func(name string, args ...string) *exec.Cmd
+}
+
+// Check implements the Checker interface.
+func (c *ExecChecker) Check(ctx context.Context) error {
+	newCommand := c.Command
+	if newCommand == nil {
+		newCommand = exec.Command
+	}
+
+	cmd := newCommand(c.Name, c.Args...)
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setpgid = true
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return cmd.Wait()
+	}
+
+	// the command runs in its own process group, so its pid is also the pgid.
+	timeout := time.Until(deadline)
+	timer := time.AfterFunc(timeout, func() {
+		syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	})
+	err := cmd.Wait()
+	if !timer.Stop() {
+		return fmt.Errorf("probe: timed out after %s", timeout.Round(time.Millisecond))
+	}
+
+	return err
+}
+
+// Result represents the last probe check result.
+type Result struct {
+	Status              Status    `json:"status"`
+	ConsecutiveFailures int       `json:"consecutive_failures"`
+	LastCheck           time.Time `json:"last_check"`
+	LastError           string    `json:"last_error,omitempty"`
+}
+
+// Probe runs its Checker every Interval after waiting InitialDelay. When the
+// check fails FailureThreshold consecutive times, OnFailure is called, and
+// again every FailureThreshold failures while it keeps failing, eg: if the
+// restart it triggered did not help.
+type Probe struct {
+	Checker          Checker
+	InitialDelay     time.Duration
+	Interval         time.Duration
+	Timeout          time.Duration
+	FailureThreshold int
+	OnFailure        func()
+
+	mu     sync.RWMutex // guards result and stop
+	result Result
+	stop   chan struct{}
+}
+
+// New returns a probe with the default interval, timeout and failure
+// threshold.
+func New(c Checker) *Probe {
+	return &Probe{
+		Checker:          c,
+		Interval:         DefaultInterval,
+		Timeout:          DefaultTimeout,
+		FailureThreshold: DefaultFailureThreshold,
+		result:           Result{Status: StatusUnknown},
+	}
+}
+
+// MarshalJSON implements json marshal interface
+func (p *Probe) MarshalJSON() ([]byte, error) {
+	return json.Marshal(p.Result())
+}
+
+// Result returns the last check result.
+func (p *Probe) Result() Result {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return p.result
+}
+
+// Start resets the probe result and starts checking in background. If the
+// probe is already running, it is restarted.
+func (p *Probe) Start() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.stop != nil {
+		close(p.stop)
+	}
+
+	stop := make(chan struct{})
+	p.stop = stop
+	p.result = Result{Status: StatusUnknown}
+
+	go p.run(stop)
+}
+
+// Stop stops checking. It does not wait for the running check to finish.
+func (p *Probe) Stop() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.stop != nil {
+		close(p.stop)
+		p.stop = nil
+	}
+}
+
+func (p *Probe) run(stop chan struct{}) {
+	select {
+	case <-stop:
+		return
+	case <-time.After(p.InitialDelay):
+	}
+
+	tick := time.NewTicker(p.Interval)
+	defer tick.Stop()
+
+	for {
+		p.check(stop)
+
+		select {
+		case <-stop:
+			return
+		case <-tick.C:
+		}
+	}
+}
+
+func (p *Probe) check(stop chan struct{}) {
+	ctx, cancel := context.WithTimeout(context.Background(), p.Timeout)
+	err := p.Checker.Check(ctx)
+	cancel()
+
+	select {
+	case <-stop:
+		return
+	default:
+	}
+
+	p.mu.Lock()
+	p.result.LastCheck = time.Now()
+	if err == nil {
+		p.result.Status = StatusSuccess
+		p.result.ConsecutiveFailures = 0
+		p.result.LastError = ""
+	} else {
+		p.result.Status = StatusFailure
+		p.result.ConsecutiveFailures++
+		p.result.LastError = err.Error()
+	}
+	threshold := p.FailureThreshold
+	if threshold < 1 {
+		threshold = 1
+	}
+	failed := err != nil && p.result.ConsecutiveFailures%threshold == 0
+	p.mu.Unlock()
+
+	if failed && p.OnFailure != nil {
+		p.OnFailure()
+	}
+}
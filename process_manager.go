@@ -5,12 +5,14 @@ import (
 
 	"github.com/WiseGrowth/go-wisebot/logger"
 	"github.com/WiseGrowth/wisebot-operator/iot"
+	"github.com/WiseGrowth/wisebot-operator/manifest"
 )
 
 // ProcessManager is in charge of starting and stoping the processes.
 type ProcessManager struct {
 	sync.Mutex
 	Services              *ServiceStore
+	Units                 *UnitLoader
 	MQTTClient            *iot.Client
 	servicesStarted       bool
 	mqttConnectionStarted bool
@@ -24,23 +26,28 @@ type ProcessManager struct {
 // The wrong value of `hasInternetConnection` can raise errors, so is mandatory
 // to check if the device is online before executing this method.
 func (pm *ProcessManager) KickOffServices(hasInternetConnection bool) error {
-	pm.Lock()
-	defer pm.Unlock()
-
 	log := logger.GetLogger()
 	log.Debug("Bootstraping and starting services")
 
+	pm.Lock()
 	if pm.servicesStarted {
+		pm.Unlock()
 		log.Debug("Process already started, ignoring KickOffServices()")
 		return nil
 	}
+	// bootstrapping and starting the services can take minutes, so the lock
+	// is not held meanwhile. The flag is set first, so they are not kicked off
+	// twice.
+	pm.servicesStarted = true
+	pm.Unlock()
 
 	if err := pm.bootstrapServices(hasInternetConnection); err != nil {
+		pm.Lock()
+		pm.servicesStarted = false
+		pm.Unlock()
 		return err
 	}
 
-	pm.servicesStarted = true
-
 	log.Debug("Bootstraping done")
 	return nil
 }
@@ -70,7 +77,8 @@ func (pm *ProcessManager) KickOffMQTTClient() error {
 	return nil
 }
 
-// Stop stops `pm.ServiceStore` services and disconnects the MQTT Client.
+// Stop stops `pm.Units` services in reverse dependency order and disconnects
+// the MQTT Client.
 func (pm *ProcessManager) Stop() {
 	pm.Lock()
 	defer pm.Unlock()
@@ -78,20 +86,29 @@ func (pm *ProcessManager) Stop() {
 	log := logger.GetLogger()
 	pm.MQTTClient.Disconnect(250)
 	log.Info("[MQTT] Disconnected")
-	pm.Services.Stop()
+	pm.Units.Stop()
 }
 
 func (pm *ProcessManager) bootstrapServices(update bool) error {
 	log := logger.GetLogger()
 
+	// daemons are bootstrapped first, since services can depend on them.
 	log.Debug("Bootstraping repos")
-	if err := pm.Services.Bootstrap(update); err != nil {
+	if err := pm.Units.Bootstrap(manifest.KindDaemon, update); err != nil {
 		return err
 	}
 
-	log.Debug("Starting commands")
-	if err := pm.Services.Start(); err != nil {
-		pm.Services.Stop()
+	if err := pm.Units.Bootstrap(manifest.KindService, update); err != nil {
+		return err
+	}
+
+	if err := pm.Units.Bootstrap(manifest.KindJob, update); err != nil {
+		return err
+	}
+
+	log.Debug("Starting commands in dependency order")
+	if err := pm.Units.Start(); err != nil {
+		pm.Units.Stop()
 		return err
 	}
 
@@ -120,9 +137,27 @@ func (pm *ProcessManager) bootstrapMQTTClient() error {
 	if err := pm.MQTTClient.Subscribe("/operator/"+wisebotConfig.WisebotID+"/service-update", updateServiceMQTTHandler); err != nil {
 		return err
 	}
+	if err := pm.MQTTClient.Subscribe("/operator/"+wisebotConfig.WisebotID+"/service-rollback", rollbackServiceMQTTHandler); err != nil {
+		return err
+	}
 	if err := pm.MQTTClient.Subscribe("/operator/"+wisebotConfig.WisebotID+"/service-restart", restartServiceMQTTHandler); err != nil {
 		return err
 	}
+	if err := pm.MQTTClient.Subscribe("/operator/"+wisebotConfig.WisebotID+"/service-clear", clearServiceMQTTHandler); err != nil {
+		return err
+	}
+	if err := pm.MQTTClient.Subscribe("/operator/"+wisebotConfig.WisebotID+"/service-logs", serviceLogsMQTTHandler); err != nil {
+		return err
+	}
+	if err := pm.MQTTClient.Subscribe("/operator/"+wisebotConfig.WisebotID+"/service-history", serviceHistoryMQTTHandler); err != nil {
+		return err
+	}
+	if err := pm.MQTTClient.Subscribe("/operator/"+wisebotConfig.WisebotID+"/service-deployments", serviceDeploymentsMQTTHandler); err != nil {
+		return err
+	}
+	if err := pm.MQTTClient.Subscribe("/operator/"+wisebotConfig.WisebotID+"/service-metrics", serviceMetricsMQTTHandler); err != nil {
+		return err
+	}
 	if err := pm.MQTTClient.Subscribe("/operator/"+wisebotConfig.WisebotID+"/daemon-start", startDaemonMQTTHandler); err != nil {
 		return err
 	}
@@ -132,15 +167,27 @@ func (pm *ProcessManager) bootstrapMQTTClient() error {
 	if err := pm.MQTTClient.Subscribe("/operator/"+wisebotConfig.WisebotID+"/daemon-update", updateDaemonMQTTHandler); err != nil {
 		return err
 	}
+	if err := pm.MQTTClient.Subscribe("/operator/"+wisebotConfig.WisebotID+"/daemon-rollback", rollbackDaemonMQTTHandler); err != nil {
+		return err
+	}
 	if err := pm.MQTTClient.Subscribe("/operator/"+wisebotConfig.WisebotID+"/daemon-restart", restartDaemonMQTTHandler); err != nil {
 		return err
 	}
+	if err := pm.MQTTClient.Subscribe("/operator/"+wisebotConfig.WisebotID+"/daemon-metrics", daemonMetricsMQTTHandler); err != nil {
+		return err
+	}
+	if err := pm.MQTTClient.Subscribe("/operator/"+wisebotConfig.WisebotID+"/daemon-deployments", daemonDeploymentsMQTTHandler); err != nil {
+		return err
+	}
 	if err := pm.MQTTClient.Subscribe("/operator/"+wisebotConfig.WisebotID+"/update", updateOperatorMQTTHandler); err != nil {
 		return err
 	}
 	if err := pm.MQTTClient.Subscribe("/operator/"+wisebotConfig.WisebotID+"/restart", restartOperatorMQTTHandler); err != nil {
 		return err
 	}
+	if err := pm.MQTTClient.Subscribe("/operator/"+wisebotConfig.WisebotID+"/reload", reloadUnitsMQTTHandler); err != nil {
+		return err
+	}
 
 	return nil
 }
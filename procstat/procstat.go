@@ -0,0 +1,202 @@
+package procstat
+
+/*
+This package reads the resources used by a process group from /proc: cpu time,
+resident memory, threads and open file descriptors. It only works on linux.
+*/
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	procPath = "/proc"
+
+	// clockTicks is the kernel USER_HZ, used by /proc/<pid>/stat cpu times.
+	// It's 100 on every architecture supported by linux.
+	clockTicks = 100
+)
+
+// DefaultHistorySize is the number of samples a History keeps by default.
+const DefaultHistorySize = 60
+
+// Sample represents the resources used by a process group at a given time.
+type Sample struct {
+	Time       time.Time `json:"time"`
+	Processes  int       `json:"processes"`
+	CPUSeconds float64   `json:"cpu_seconds"`
+	CPUPercent float64   `json:"cpu_percent"`
+	RSSBytes   uint64    `json:"rss_bytes"`
+	Threads    int       `json:"threads"`
+	FDs        int       `json:"fds"`
+}
+
+// stat represents the /proc/<pid>/stat fields we care about.
+type stat struct {
+	pgid    int
+	ticks   uint64 // utime + stime
+	threads int
+	rss     uint64 // pages
+}
+
+// ProcessGroup samples every process that belongs to the process group of
+// the given pid.
+func ProcessGroup(pid int) (*Sample, error) {
+	leader, err := readStat(pid)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := ioutil.ReadDir(procPath)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Sample{Time: time.Now()}
+	pageSize := uint64(os.Getpagesize())
+
+	for _, e := range entries {
+		p, err := strconv.Atoi(e.Name())
+		if err != nil {
+			continue
+		}
+
+		st, err := readStat(p)
+		// the process could have exited while reading /proc.
+		if err != nil || st.pgid != leader.pgid {
+			continue
+		}
+
+		s.Processes++
+		s.CPUSeconds += float64(st.ticks) / clockTicks
+		s.RSSBytes += st.rss * pageSize
+		s.Threads += st.threads
+		s.FDs += countFDs(p)
+	}
+
+	return s, nil
+}
+
+func readStat(pid int) (*stat, error) {
+	b, err := ioutil.ReadFile(filepath.Join(procPath, strconv.Itoa(pid), "stat"))
+	if err != nil {
+		return nil, err
+	}
+
+	// the command name is wrapped by parenthesis and can contain spaces, so
+	// the fields are read after the last parenthesis.
+	content := string(b)
+	i := strings.LastIndexByte(content, ')')
+	if i < 0 {
+		return nil, fmt.Errorf("procstat: invalid stat file for pid %d", pid)
+	}
+
+	// fields[0] is the process state, the third field of the stat file.
+	fields := strings.Fields(content[i+1:])
+	if len(fields) < 22 {
+		return nil, fmt.Errorf("procstat: invalid stat file for pid %d", pid)
+	}
+
+	st := new(stat)
+	values := []struct {
+		field int
+		dst   interface{}
+	}{
+		{2, &st.pgid},
+		{11, &st.ticks}, // utime
+		{17, &st.threads},
+		{21, &st.rss},
+	}
+	for _, v := range values {
+		if _, err := fmt.Sscan(fields[v.field], v.dst); err != nil {
+			return nil, err
+		}
+	}
+
+	var stime uint64
+	if _, err := fmt.Sscan(fields[12], &stime); err != nil {
+		return nil, err
+	}
+	st.ticks += stime
+
+	return st, nil
+}
+
+// countFDs returns the number of open file descriptors of the process, or 0
+// if they can't be read.
+func countFDs(pid int) int {
+	entries, err := ioutil.ReadDir(filepath.Join(procPath, strconv.Itoa(pid), "fd"))
+	if err != nil {
+		return 0
+	}
+
+	return len(entries)
+}
+
+// History keeps the last samples of a process group in a rolling window.
+type History struct {
+	mu      sync.RWMutex // guards samples
+	size    int
+	samples []Sample
+}
+
+// NewHistory returns a History that keeps the last size samples.
+func NewHistory(size int) *History {
+	if size < 1 {
+		size = DefaultHistorySize
+	}
+
+	return &History{size: size}
+}
+
+// Add appends the sample to the history. The sample cpu percent is computed
+// from the previous sample.
+func (h *History) Add(s Sample) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if n := len(h.samples); n > 0 {
+		prev := h.samples[n-1]
+		elapsed := s.Time.Sub(prev.Time).Seconds()
+		// the cpu time decreases when the process was restarted.
+		if cpu := s.CPUSeconds - prev.CPUSeconds; elapsed > 0 && cpu > 0 {
+			s.CPUPercent = cpu / elapsed * 100
+		}
+	}
+
+	h.samples = append(h.samples, s)
+	if len(h.samples) > h.size {
+		h.samples = h.samples[len(h.samples)-h.size:]
+	}
+}
+
+// Last returns the last sample, or nil if there are no samples.
+func (h *History) Last() *Sample {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	if len(h.samples) == 0 {
+		return nil
+	}
+
+	s := h.samples[len(h.samples)-1]
+	return &s
+}
+
+// Samples returns a copy of the kept samples, from the oldest to the newest.
+func (h *History) Samples() []Sample {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	samples := make([]Sample, len(h.samples))
+	copy(samples, h.samples)
+
+	return samples
+}
@@ -2,9 +2,10 @@ package main
 
 import (
 	"encoding/json"
-	"os/exec"
+	"time"
 
 	"github.com/WiseGrowth/go-wisebot/logger"
+	"github.com/WiseGrowth/wisebot-operator/git"
 	MQTT "github.com/eclipse/paho.mqtt.golang"
 	"github.com/sirupsen/logrus"
 )
@@ -15,10 +16,42 @@ type actionPayload struct {
 	Name string `json:"name"`
 }
 
+// logsPayload represents the received payload for requesting service logs.
+type logsPayload struct {
+	Name  string `json:"name"`
+	Lines int    `json:"lines"`
+}
+
+// rollbackPayload represents the received payload for rolling back daemons and
+// services. An empty version rolls back to the previous deployment.
+type rollbackPayload struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
 type updatePayload struct {
 	NewVersion string `json:"version"`
 }
 
+// Events published to the `/operator/:wisebot-id/events` topic.
+const (
+	eventServiceCrashLoop = "service-crash-loop"
+	eventServiceBlocked   = "service-blocked"
+	eventJobFailed        = "job-failed"
+	eventUpdateFailed     = "update-failed"
+)
+
+// eventPayload represents the message published for each operator event.
+type eventPayload struct {
+	Event string      `json:"event"`
+	Data  interface{} `json:"data"`
+	Meta  eventMeta   `json:"meta"`
+}
+
+type eventMeta struct {
+	Timestamp time.Time `json:"timestamp"`
+}
+
 func healthzMQTTHandler(client MQTT.Client, message MQTT.Message) {
 	topic := message.Topic()
 
@@ -152,7 +185,7 @@ func updateServiceMQTTHandler(client MQTT.Client, message MQTT.Message) {
 		return
 	}
 
-	if err := processManager.Services.Update(payload.Name); err != nil {
+	if err := processManager.Services.Update(payload.Name, git.TriggerMQTT); err != nil {
 		log.Error(err)
 		return
 	}
@@ -173,7 +206,49 @@ func updateDaemonMQTTHandler(client MQTT.Client, message MQTT.Message) {
 		return
 	}
 
-	if err := daemonStore.Update(payload.Name); err != nil {
+	if err := daemonStore.Update(payload.Name, git.TriggerMQTT); err != nil {
+		log.Error(err)
+		return
+	}
+}
+
+func rollbackServiceMQTTHandler(client MQTT.Client, message MQTT.Message) {
+	topic := message.Topic()
+	log := logger.GetLogger().WithField("topic", topic)
+
+	defer publishHealthz(client, log)
+
+	log.Info("Message received")
+
+	payload := new(rollbackPayload)
+
+	if err := json.Unmarshal(message.Payload(), &payload); err != nil {
+		log.Error(err)
+		return
+	}
+
+	if err := processManager.Services.Rollback(payload.Name, payload.Version, git.TriggerMQTT); err != nil {
+		log.Error(err)
+		return
+	}
+}
+
+func rollbackDaemonMQTTHandler(client MQTT.Client, message MQTT.Message) {
+	topic := message.Topic()
+	log := logger.GetLogger().WithField("topic", topic)
+
+	defer publishHealthz(client, log)
+
+	log.Info("Message received")
+
+	payload := new(rollbackPayload)
+
+	if err := json.Unmarshal(message.Payload(), &payload); err != nil {
+		log.Error(err)
+		return
+	}
+
+	if err := daemonStore.Rollback(payload.Name, payload.Version, git.TriggerMQTT); err != nil {
 		log.Error(err)
 		return
 	}
@@ -199,6 +274,225 @@ func restartDaemonMQTTHandler(client MQTT.Client, message MQTT.Message) {
 	}
 }
 
+func reloadUnitsMQTTHandler(client MQTT.Client, message MQTT.Message) {
+	topic := message.Topic()
+	log := logger.GetLogger().WithField("topic", topic)
+
+	defer publishHealthz(client, log)
+	log.Info("Message received")
+
+	if err := reloadUnits(); err != nil {
+		log.Error(err)
+		return
+	}
+}
+
+func clearServiceMQTTHandler(client MQTT.Client, message MQTT.Message) {
+	topic := message.Topic()
+	log := logger.GetLogger().WithField("topic", topic)
+
+	defer publishHealthz(client, log)
+	log.Info("Message received")
+
+	payload := new(actionPayload)
+
+	if err := json.Unmarshal(message.Payload(), &payload); err != nil {
+		log.Error(err)
+		return
+	}
+
+	if err := processManager.Services.ClearService(payload.Name); err != nil {
+		log.Error(err)
+		return
+	}
+}
+
+func serviceLogsMQTTHandler(client MQTT.Client, message MQTT.Message) {
+	topic := message.Topic()
+	log := logger.GetLogger().WithField("topic", topic)
+	log.Info("Message received")
+
+	payload := &logsPayload{Lines: defaultServiceLogsLines}
+
+	if err := json.Unmarshal(message.Payload(), &payload); err != nil {
+		publishError(client, topic, log, err)
+		return
+	}
+
+	logs, err := processManager.Services.Logs(payload.Name, payload.Lines)
+	if err != nil {
+		publishError(client, topic, log, err)
+		return
+	}
+
+	publishResponse(client, topic, log, struct {
+		Data serviceLogsResponse `json:"data"`
+	}{Data: serviceLogsResponse{Name: payload.Name, Lines: logs}})
+}
+
+func serviceHistoryMQTTHandler(client MQTT.Client, message MQTT.Message) {
+	topic := message.Topic()
+	log := logger.GetLogger().WithField("topic", topic)
+	log.Info("Message received")
+
+	payload := new(actionPayload)
+
+	if err := json.Unmarshal(message.Payload(), &payload); err != nil {
+		publishError(client, topic, log, err)
+		return
+	}
+
+	history, err := processManager.Services.History(payload.Name)
+	if err != nil {
+		publishError(client, topic, log, err)
+		return
+	}
+
+	publishResponse(client, topic, log, struct {
+		Data serviceHistoryResponse `json:"data"`
+	}{Data: serviceHistoryResponse{Name: payload.Name, History: history}})
+}
+
+func serviceDeploymentsMQTTHandler(client MQTT.Client, message MQTT.Message) {
+	topic := message.Topic()
+	log := logger.GetLogger().WithField("topic", topic)
+	log.Info("Message received")
+
+	payload := new(actionPayload)
+
+	if err := json.Unmarshal(message.Payload(), &payload); err != nil {
+		publishError(client, topic, log, err)
+		return
+	}
+
+	deployments, err := processManager.Services.Deployments(payload.Name)
+	if err != nil {
+		publishError(client, topic, log, err)
+		return
+	}
+
+	publishResponse(client, topic, log, struct {
+		Data deploymentsResponse `json:"data"`
+	}{Data: deploymentsResponse{Name: payload.Name, Deployments: deployments}})
+}
+
+func daemonDeploymentsMQTTHandler(client MQTT.Client, message MQTT.Message) {
+	topic := message.Topic()
+	log := logger.GetLogger().WithField("topic", topic)
+	log.Info("Message received")
+
+	payload := new(actionPayload)
+
+	if err := json.Unmarshal(message.Payload(), &payload); err != nil {
+		publishError(client, topic, log, err)
+		return
+	}
+
+	deployments, err := daemonStore.Deployments(payload.Name)
+	if err != nil {
+		publishError(client, topic, log, err)
+		return
+	}
+
+	publishResponse(client, topic, log, struct {
+		Data deploymentsResponse `json:"data"`
+	}{Data: deploymentsResponse{Name: payload.Name, Deployments: deployments}})
+}
+
+func serviceMetricsMQTTHandler(client MQTT.Client, message MQTT.Message) {
+	topic := message.Topic()
+	log := logger.GetLogger().WithField("topic", topic)
+	log.Info("Message received")
+
+	payload := new(actionPayload)
+
+	if err := json.Unmarshal(message.Payload(), &payload); err != nil {
+		publishError(client, topic, log, err)
+		return
+	}
+
+	metrics, err := processManager.Services.Metrics(payload.Name)
+	if err != nil {
+		publishError(client, topic, log, err)
+		return
+	}
+
+	publishResponse(client, topic, log, struct {
+		Data metricsResponse `json:"data"`
+	}{Data: metricsResponse{Name: payload.Name, Samples: metrics.Samples()}})
+}
+
+func daemonMetricsMQTTHandler(client MQTT.Client, message MQTT.Message) {
+	topic := message.Topic()
+	log := logger.GetLogger().WithField("topic", topic)
+	log.Info("Message received")
+
+	payload := new(actionPayload)
+
+	if err := json.Unmarshal(message.Payload(), &payload); err != nil {
+		publishError(client, topic, log, err)
+		return
+	}
+
+	metrics, err := daemonStore.Metrics(payload.Name)
+	if err != nil {
+		publishError(client, topic, log, err)
+		return
+	}
+
+	publishResponse(client, topic, log, struct {
+		Data metricsResponse `json:"data"`
+	}{Data: metricsResponse{Name: payload.Name, Samples: metrics.Samples()}})
+}
+
+// publishEvent publishes the given event to the events topic. If the MQTT
+// client is not connected, the event is only logged.
+func publishEvent(event string, data interface{}) {
+	log := logger.GetLogger().WithField("event", event)
+
+	client := processManager.MQTTClient
+	if client == nil || !client.IsConnected() {
+		log.Warn("MQTT client not connected, event not published")
+		return
+	}
+
+	payload := &eventPayload{Event: event, Data: data}
+	payload.Meta.Timestamp = time.Now()
+
+	payloadBytes, err := json.Marshal(payload)
+	if err != nil {
+		log.Error(err)
+		return
+	}
+
+	token := client.Publish(eventsPublishableTopic, byte(1), false, payloadBytes)
+	if token.Wait() && token.Error() != nil {
+		log.Error(token.Error())
+	}
+}
+
+// errorResponse is published on the response topic of a failed request.
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// publishResponse publishes the response to the request received in topic.
+func publishResponse(client MQTT.Client, topic string, log *logrus.Entry, response interface{}) {
+	responseBytes, _ := json.Marshal(response)
+
+	token := client.Publish(topic+":response", byte(1), false, responseBytes)
+	if token.Wait() && token.Error() != nil {
+		log.Error(token.Error())
+	}
+}
+
+// publishError logs the error of the request received in topic and publishes
+// it as the response, so the requester does not wait for one forever.
+func publishError(client MQTT.Client, topic string, log *logrus.Entry, err error) {
+	log.Error(err)
+	publishResponse(client, topic, log, errorResponse{Error: err.Error()})
+}
+
 func publishHealthz(client MQTT.Client, log *logrus.Entry) {
 	responseBytes, _ := json.Marshal(newHealthResponse())
 
@@ -230,8 +524,7 @@ func updateOperatorMQTTHandler(client MQTT.Client, message MQTT.Message) {
 	publishHealthz(client, log)
 	processManager.Stop()
 
-	//TODO: implement support for daemon without a code's repository
-	exec.Command("sudo", "systemctl", "restart", "operator").Run()
+	restartOperator()
 
 	return
 }
@@ -243,8 +536,7 @@ func restartOperatorMQTTHandler(client MQTT.Client, message MQTT.Message) {
 	publishHealthz(client, log)
 	processManager.Stop()
 
-	//TODO: implement support for daemon without a code's repository
-	exec.Command("sudo", "systemctl", "restart", "operator").Run()
+	restartOperator()
 
 	return
 }
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"time"
+
+	"github.com/WiseGrowth/wisebot-operator/manifest"
+)
+
+// Restart and crash-loop policies defaults
+const (
+	defaultRestartBackoff    = 1 * time.Second
+	defaultRestartMaxBackoff = 1 * time.Minute
+	defaultRestartResetAfter = 5 * time.Minute
+
+	defaultCrashLoopMaxExits = 5
+	defaultCrashLoopWindow   = 3 * time.Minute
+)
+
+// restartPolicy decides if and when an exited service is restarted.
+type restartPolicy struct {
+	policy     string
+	maxRetries int
+	backoff    time.Duration
+	maxBackoff time.Duration
+	resetAfter time.Duration
+}
+
+// newRestartPolicy builds the restart policy declared in the manifest and
+// fills the missing values with its defaults. Services without a restart
+// policy are never restarted.
+func newRestartPolicy(p *manifest.RestartPolicy) restartPolicy {
+	if p == nil {
+		return restartPolicy{policy: manifest.RestartNever}
+	}
+
+	rp := restartPolicy{
+		policy:     p.Policy,
+		maxRetries: p.MaxRetries,
+		backoff:    p.Backoff.Duration,
+		maxBackoff: p.MaxBackoff.Duration,
+		resetAfter: p.ResetAfter.Duration,
+	}
+
+	if rp.backoff <= 0 {
+		rp.backoff = defaultRestartBackoff
+	}
+	if rp.maxBackoff <= 0 {
+		rp.maxBackoff = defaultRestartMaxBackoff
+	}
+	if rp.resetAfter <= 0 {
+		rp.resetAfter = defaultRestartResetAfter
+	}
+
+	return rp
+}
+
+// shouldRestart indicates if the service must be restarted given the error the
+// command exited with.
+func (rp restartPolicy) shouldRestart(exitErr error) bool {
+	switch rp.policy {
+	case manifest.RestartAlways:
+		return true
+	case manifest.RestartOnFailure:
+		return exitErr != nil
+	default:
+		return false
+	}
+}
+
+// exhausted indicates if the service reached the max number of consecutive
+// restarts.
+func (rp restartPolicy) exhausted(retries int) bool {
+	return rp.maxRetries > 0 && retries >= rp.maxRetries
+}
+
+// delay returns how long to wait before the given retry. The backoff is
+// doubled on every retry until reaching maxBackoff.
+func (rp restartPolicy) delay(retry int) time.Duration {
+	d := rp.backoff
+	for i := 0; i < retry && d < rp.maxBackoff; i++ {
+		d *= 2
+	}
+
+	if d > rp.maxBackoff {
+		d = rp.maxBackoff
+	}
+
+	return d
+}
+
+// crashLoopDetector keeps the service exit times in order to detect if the
+// service is exiting right after it starts.
+type crashLoopDetector struct {
+	maxExits int
+	window   time.Duration
+	exits    []time.Time
+}
+
+// newCrashLoopDetector builds the crash-loop detector declared in the manifest
+// and fills the missing values with its defaults.
+func newCrashLoopDetector(p *manifest.CrashLoopPolicy) *crashLoopDetector {
+	d := &crashLoopDetector{
+		maxExits: defaultCrashLoopMaxExits,
+		window:   defaultCrashLoopWindow,
+	}
+
+	if p != nil {
+		if p.MaxExits > 0 {
+			d.maxExits = p.MaxExits
+		}
+		if p.Window.Duration > 0 {
+			d.window = p.Window.Duration
+		}
+	}
+
+	return d
+}
+
+// exited records an exit at the given time and returns true if the service
+// exited maxExits times within the window.
+func (d *crashLoopDetector) exited(at time.Time) bool {
+	exits := d.exits[:0]
+	for _, t := range d.exits {
+		if at.Sub(t) < d.window {
+			exits = append(exits, t)
+		}
+	}
+	d.exits = append(exits, at)
+
+	return len(d.exits) >= d.maxExits
+}
+
+// reset forgets the recorded exits.
+func (d *crashLoopDetector) reset() {
+	d.exits = nil
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/WiseGrowth/wisebot-operator/manifest"
+)
+
+func TestNewRestartPolicy(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy *manifest.RestartPolicy
+		want   restartPolicy
+	}{
+		{
+			"no policy",
+			nil,
+			restartPolicy{policy: manifest.RestartNever},
+		},
+		{
+			"defaults",
+			&manifest.RestartPolicy{Policy: manifest.RestartAlways},
+			restartPolicy{
+				policy:     manifest.RestartAlways,
+				backoff:    defaultRestartBackoff,
+				maxBackoff: defaultRestartMaxBackoff,
+				resetAfter: defaultRestartResetAfter,
+			},
+		},
+		{
+			"declared values",
+			&manifest.RestartPolicy{
+				Policy:     manifest.RestartOnFailure,
+				MaxRetries: 3,
+				Backoff:    manifest.Duration{Duration: 2 * time.Second},
+				MaxBackoff: manifest.Duration{Duration: 10 * time.Second},
+				ResetAfter: manifest.Duration{Duration: time.Minute},
+			},
+			restartPolicy{
+				policy:     manifest.RestartOnFailure,
+				maxRetries: 3,
+				backoff:    2 * time.Second,
+				maxBackoff: 10 * time.Second,
+				resetAfter: time.Minute,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		if got := newRestartPolicy(tt.policy); got != tt.want {
+			t.Errorf("%s: newRestartPolicy = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRestartPolicyShouldRestart(t *testing.T) {
+	exitErr := errors.New("exit status 1")
+
+	tests := []struct {
+		policy string
+		err    error
+		want   bool
+	}{
+		{manifest.RestartAlways, nil, true},
+		{manifest.RestartAlways, exitErr, true},
+		{manifest.RestartOnFailure, nil, false},
+		{manifest.RestartOnFailure, exitErr, true},
+		{manifest.RestartNever, nil, false},
+		{manifest.RestartNever, exitErr, false},
+	}
+
+	for _, tt := range tests {
+		rp := restartPolicy{policy: tt.policy}
+		if got := rp.shouldRestart(tt.err); got != tt.want {
+			t.Errorf("%s: shouldRestart(%v) = %t, want %t", tt.policy, tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestRestartPolicyExhausted(t *testing.T) {
+	tests := []struct {
+		maxRetries, retries int
+		want                bool
+	}{
+		{0, 0, false},
+		{0, 100, false},
+		{3, 2, false},
+		{3, 3, true},
+		{3, 4, true},
+	}
+
+	for _, tt := range tests {
+		rp := restartPolicy{maxRetries: tt.maxRetries}
+		if got := rp.exhausted(tt.retries); got != tt.want {
+			t.Errorf("max retries %d: exhausted(%d) = %t, want %t", tt.maxRetries, tt.retries, got, tt.want)
+		}
+	}
+}
+
+func TestRestartPolicyDelay(t *testing.T) {
+	rp := restartPolicy{backoff: time.Second, maxBackoff: 10 * time.Second}
+
+	tests := []struct {
+		retry int
+		want  time.Duration
+	}{
+		{0, time.Second},
+		{1, 2 * time.Second},
+		{2, 4 * time.Second},
+		{3, 8 * time.Second},
+		{4, 10 * time.Second},
+		{100, 10 * time.Second},
+	}
+
+	for _, tt := range tests {
+		if got := rp.delay(tt.retry); got != tt.want {
+			t.Errorf("delay(%d) = %s, want %s", tt.retry, got, tt.want)
+		}
+	}
+
+	// a backoff bigger than the max is capped.
+	rp = restartPolicy{backoff: time.Minute, maxBackoff: 10 * time.Second}
+	if got := rp.delay(0); got != 10*time.Second {
+		t.Errorf("delay(0) with a backoff bigger than the max = %s, want %s", got, 10*time.Second)
+	}
+}
+
+func TestNewCrashLoopDetector(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   *manifest.CrashLoopPolicy
+		maxExits int
+		window   time.Duration
+	}{
+		{"no policy", nil, defaultCrashLoopMaxExits, defaultCrashLoopWindow},
+		{"defaults", &manifest.CrashLoopPolicy{}, defaultCrashLoopMaxExits, defaultCrashLoopWindow},
+		{
+			"declared values",
+			&manifest.CrashLoopPolicy{MaxExits: 3, Window: manifest.Duration{Duration: time.Minute}},
+			3, time.Minute,
+		},
+	}
+
+	for _, tt := range tests {
+		d := newCrashLoopDetector(tt.policy)
+		if d.maxExits != tt.maxExits || d.window != tt.window {
+			t.Errorf("%s: newCrashLoopDetector = %d exits in %s, want %d exits in %s", tt.name, d.maxExits, d.window, tt.maxExits, tt.window)
+		}
+	}
+}
+
+func TestCrashLoopDetectorExited(t *testing.T) {
+	start := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name  string
+		exits []time.Duration // since start
+		want  bool
+	}{
+		{"no loop", []time.Duration{0, 10 * time.Second}, false},
+		{"loop", []time.Duration{0, 10 * time.Second, 20 * time.Second}, true},
+		{"exits spread out of the window", []time.Duration{0, 40 * time.Second, 80 * time.Second}, false},
+		{"exit at the window edge is forgotten", []time.Duration{0, 30 * time.Second, time.Minute}, false},
+		{"loop after old exits", []time.Duration{0, 2 * time.Minute, 2*time.Minute + 10*time.Second, 2*time.Minute + 20*time.Second}, true},
+	}
+
+	for _, tt := range tests {
+		d := &crashLoopDetector{maxExits: 3, window: time.Minute}
+
+		var got bool
+		for _, e := range tt.exits {
+			got = d.exited(start.Add(e))
+		}
+
+		if got != tt.want {
+			t.Errorf("%s: exited = %t, want %t", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCrashLoopDetectorReset(t *testing.T) {
+	start := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	d := &crashLoopDetector{maxExits: 2, window: time.Minute}
+
+	d.exited(start)
+	d.reset()
+
+	if d.exited(start.Add(time.Second)) {
+		t.Errorf("exited after reset = true, want false")
+	}
+	if !d.exited(start.Add(2 * time.Second)) {
+		t.Errorf("second exited after reset = false, want true")
+	}
+}
@@ -0,0 +1,245 @@
+package semver
+
+/*
+This package parses semantic versions, eg: v1.4.2, and the ranges used to pick
+a release among the repository tags. A range is a space separated list of
+comparators that must all match: exact versions (1.2.3), operators (>=1.2.0
+<2.0.0), carets (^1.2.0, same major), tildes (~1.2.0, same minor) and
+wildcards (1.x, 1.2.*, *). Pre-release versions never match a range.
+*/
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Version represents a semantic version.
+type Version struct {
+	Major, Minor, Patch int
+	Pre                 string // pre-release, eg: rc.1
+}
+
+// Parse parses a version, with or without the `v` prefix. Build metadata is
+// ignored.
+func Parse(s string) (Version, error) {
+	var v Version
+
+	str := strings.TrimPrefix(s, "v")
+	if i := strings.IndexByte(str, '+'); i >= 0 {
+		str = str[:i]
+	}
+	if i := strings.IndexByte(str, '-'); i >= 0 {
+		v.Pre = str[i+1:]
+		str = str[:i]
+	}
+
+	parts := strings.Split(str, ".")
+	if len(parts) != 3 {
+		return v, fmt.Errorf("semver: invalid version %q", s)
+	}
+
+	nums := make([]int, 3)
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return v, fmt.Errorf("semver: invalid version %q", s)
+		}
+		nums[i] = n
+	}
+	v.Major, v.Minor, v.Patch = nums[0], nums[1], nums[2]
+
+	return v, nil
+}
+
+// String returns the version without the `v` prefix.
+func (v Version) String() string {
+	s := fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+	if len(v.Pre) > 0 {
+		s += "-" + v.Pre
+	}
+
+	return s
+}
+
+// Compare returns -1, 0 or 1 if v is lower, equal or greater than o. A
+// pre-release is lower than its release, and pre-releases are compared as
+// strings.
+func (v Version) Compare(o Version) int {
+	for _, d := range []int{v.Major - o.Major, v.Minor - o.Minor, v.Patch - o.Patch} {
+		if d < 0 {
+			return -1
+		}
+		if d > 0 {
+			return 1
+		}
+	}
+
+	switch {
+	case v.Pre == o.Pre:
+		return 0
+	case len(v.Pre) == 0:
+		return 1
+	case len(o.Pre) == 0:
+		return -1
+	case v.Pre < o.Pre:
+		return -1
+	default:
+		return 1
+	}
+}
+
+type comparator struct {
+	op string
+	v  Version
+}
+
+func (c comparator) match(v Version) bool {
+	cmp := v.Compare(c.v)
+	switch c.op {
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	default:
+		return cmp == 0
+	}
+}
+
+// Range represents a set of versions.
+type Range struct {
+	spec        string
+	comparators []comparator
+}
+
+// ParseRange parses a range.
+func ParseRange(spec string) (*Range, error) {
+	r := &Range{spec: spec}
+
+	fields := strings.Fields(spec)
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("semver: empty range")
+	}
+
+	for _, f := range fields {
+		cs, err := parseComparator(f)
+		if err != nil {
+			return nil, fmt.Errorf("semver: invalid range %q", spec)
+		}
+		r.comparators = append(r.comparators, cs...)
+	}
+
+	return r, nil
+}
+
+// String returns the range spec.
+func (r *Range) String() string {
+	return r.spec
+}
+
+// Match returns true if the version is in the range.
+func (r *Range) Match(v Version) bool {
+	if len(v.Pre) > 0 {
+		return false
+	}
+
+	for _, c := range r.comparators {
+		if !c.match(v) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// parseComparator parses a single range field into the comparators it stands
+// for, eg: ^1.2.0 is >=1.2.0 <2.0.0.
+func parseComparator(f string) ([]comparator, error) {
+	for _, op := range []string{">=", "<=", ">", "<", "="} {
+		if strings.HasPrefix(f, op) {
+			v, err := parsePartial(f[len(op):])
+			if err != nil {
+				return nil, err
+			}
+			return []comparator{{op, v}}, nil
+		}
+	}
+
+	switch {
+	case strings.HasPrefix(f, "^"):
+		v, err := Parse(f[1:])
+		if err != nil {
+			return nil, err
+		}
+
+		upper := Version{Major: v.Major + 1}
+		switch {
+		case v.Major == 0 && v.Minor == 0:
+			upper = Version{Patch: v.Patch + 1}
+		case v.Major == 0:
+			upper = Version{Minor: v.Minor + 1}
+		}
+		return []comparator{{">=", v}, {"<", upper}}, nil
+	case strings.HasPrefix(f, "~"):
+		v, err := Parse(f[1:])
+		if err != nil {
+			return nil, err
+		}
+		return []comparator{{">=", v}, {"<", Version{Major: v.Major, Minor: v.Minor + 1}}}, nil
+	}
+
+	return parseWildcard(f)
+}
+
+// parsePartial parses a version that may omit the minor and patch numbers,
+// eg: 2 is 2.0.0.
+func parsePartial(s string) (Version, error) {
+	if n := strings.Count(s, "."); n < 2 && !strings.ContainsAny(s, "-+") {
+		s += strings.Repeat(".0", 2-n)
+	}
+
+	return Parse(s)
+}
+
+// parseWildcard parses an exact version or a version with wildcards, eg: 1.x.
+func parseWildcard(f string) ([]comparator, error) {
+	parts := strings.Split(strings.TrimPrefix(f, "v"), ".")
+	if len(parts) > 3 {
+		return nil, fmt.Errorf("invalid version %q", f)
+	}
+
+	nums := make([]int, 0, 3)
+	for _, p := range parts {
+		if p == "x" || p == "X" || p == "*" {
+			break
+		}
+
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid version %q", f)
+		}
+		nums = append(nums, n)
+	}
+
+	switch len(nums) {
+	case 0:
+		return nil, nil
+	case 1:
+		return []comparator{{">=", Version{Major: nums[0]}}, {"<", Version{Major: nums[0] + 1}}}, nil
+	case 2:
+		return []comparator{
+			{">=", Version{Major: nums[0], Minor: nums[1]}},
+			{"<", Version{Major: nums[0], Minor: nums[1] + 1}},
+		}, nil
+	}
+
+	v, err := Parse(f)
+	if err != nil {
+		return nil, err
+	}
+	return []comparator{{"=", v}}, nil
+}
@@ -0,0 +1,117 @@
+package semver
+
+import "testing"
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		s    string
+		want Version
+	}{
+		{"1.4.2", Version{Major: 1, Minor: 4, Patch: 2}},
+		{"v1.4.2", Version{Major: 1, Minor: 4, Patch: 2}},
+		{"v0.0.1-rc.1", Version{Patch: 1, Pre: "rc.1"}},
+		{"1.0.0+build.5", Version{Major: 1}},
+		{"2.0.0-beta+exp", Version{Major: 2, Pre: "beta"}},
+	}
+
+	for _, tt := range tests {
+		got, err := Parse(tt.s)
+		if err != nil {
+			t.Errorf("Parse(%q): unexpected error: %s", tt.s, err)
+			continue
+		}
+
+		if got != tt.want {
+			t.Errorf("Parse(%q) = %+v, want %+v", tt.s, got, tt.want)
+		}
+	}
+
+	for _, s := range []string{"", "1", "1.2", "1.2.3.4", "a.b.c", "1.-2.3", "latest"} {
+		if _, err := Parse(s); err == nil {
+			t.Errorf("Parse(%q): expected an error", s)
+		}
+	}
+}
+
+func TestCompare(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"1.0.0", "1.0.0", 0},
+		{"1.0.0", "2.0.0", -1},
+		{"1.10.0", "1.9.0", 1},
+		{"1.0.10", "1.0.9", 1},
+		{"1.0.0-rc.1", "1.0.0", -1},
+		{"1.0.0", "1.0.0-rc.1", 1},
+		{"1.0.0-alpha", "1.0.0-beta", -1},
+	}
+
+	for _, tt := range tests {
+		a, b := mustParse(t, tt.a), mustParse(t, tt.b)
+		if got := a.Compare(b); got != tt.want {
+			t.Errorf("%s.Compare(%s) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestRangeMatch(t *testing.T) {
+	tests := []struct {
+		spec    string
+		match   []string
+		noMatch []string
+	}{
+		{"1.4.2", []string{"1.4.2", "v1.4.2"}, []string{"1.4.3", "1.4.1"}},
+		{"=1.4.2", []string{"1.4.2"}, []string{"1.4.3"}},
+		{"^1.4.0", []string{"1.4.0", "1.9.9"}, []string{"1.3.9", "2.0.0", "1.5.0-rc.1"}},
+		{"^0.2.3", []string{"0.2.3", "0.2.9"}, []string{"0.3.0", "0.2.2"}},
+		{"^0.0.3", []string{"0.0.3"}, []string{"0.0.4"}},
+		{"~1.4.0", []string{"1.4.0", "1.4.9"}, []string{"1.5.0", "1.3.9"}},
+		{"1.x", []string{"1.0.0", "1.9.9"}, []string{"2.0.0", "0.9.9"}},
+		{"1.2.*", []string{"1.2.0", "1.2.7"}, []string{"1.3.0"}},
+		{"*", []string{"0.0.1", "9.9.9"}, []string{"1.0.0-rc.1"}},
+		{">=1.4.0 <2.0.0", []string{"1.4.0", "1.99.0"}, []string{"1.3.9", "2.0.0"}},
+		{">1.4.0", []string{"1.4.1"}, []string{"1.4.0"}},
+		{"<=2", []string{"2.0.0", "1.9.9"}, []string{"2.0.1"}},
+		{">=1.2 <1.3", []string{"1.2.0", "1.2.9"}, []string{"1.3.0"}},
+	}
+
+	for _, tt := range tests {
+		r, err := ParseRange(tt.spec)
+		if err != nil {
+			t.Errorf("ParseRange(%q): unexpected error: %s", tt.spec, err)
+			continue
+		}
+
+		for _, s := range tt.match {
+			if !r.Match(mustParse(t, s)) {
+				t.Errorf("ParseRange(%q).Match(%s) = false, want true", tt.spec, s)
+			}
+		}
+
+		for _, s := range tt.noMatch {
+			if r.Match(mustParse(t, s)) {
+				t.Errorf("ParseRange(%q).Match(%s) = true, want false", tt.spec, s)
+			}
+		}
+	}
+}
+
+func TestParseRangeErrors(t *testing.T) {
+	for _, spec := range []string{"", "  ", "^", "~1", ">=a", "1.2.3.4", "1.y", "latest"} {
+		if _, err := ParseRange(spec); err == nil {
+			t.Errorf("ParseRange(%q): expected an error", spec)
+		}
+	}
+}
+
+func mustParse(t *testing.T, s string) Version {
+	t.Helper()
+
+	v, err := Parse(s)
+	if err != nil {
+		t.Fatalf("Parse(%q): unexpected error: %s", s, err)
+	}
+
+	return v
+}
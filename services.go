@@ -3,6 +3,9 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"io"
+	"os"
+	"os/exec"
 	"sync"
 	"time"
 
@@ -10,8 +13,12 @@ import (
 
 	"github.com/WiseGrowth/go-wisebot/led"
 	"github.com/WiseGrowth/go-wisebot/logger"
+	"github.com/WiseGrowth/wisebot-operator/cgroup"
 	"github.com/WiseGrowth/wisebot-operator/command"
 	"github.com/WiseGrowth/wisebot-operator/git"
+	"github.com/WiseGrowth/wisebot-operator/probe"
+	"github.com/WiseGrowth/wisebot-operator/procstat"
+	"github.com/WiseGrowth/wisebot-operator/watchdog"
 )
 
 const (
@@ -20,57 +27,315 @@ const (
 
 // Service encapsulates a command an its repository
 type Service struct {
-	Name     string
-	cmd      *command.Command
-	repo     *git.Repo
-	finished chan error    // command error
-	stop     chan struct{} // stop command watcher
+	Name    string
+	cmd     *command.Command
+	repo    *git.Repo
+	logFile io.Closer     // command output file
+	store   *ServiceStore // store the service belongs to, used to restart it.
+	stop    chan struct{} // stop command watcher
+
+	liveness  *probe.Probe // restarts the service when failing
+	readiness *probe.Probe
+	watchdog  *watchdog.Watchdog // restarts the service when no heartbeat arrives
+
+	history *serviceHistory
+	metrics *procstat.History // resource usage samples
+
+	blockedBy string // required unit that is not up
+
+	env    *serviceEnv // nil if the service inherits the operator environment
+	cgroup *cgroup.Group
+
+	hooks       serviceHooks
+	hookFailure *hookFailure // last failed hook since the service started
+
+	restartPolicy restartPolicy
+	crashLoop     *crashLoopDetector
+	startedAt     time.Time
+	retries       int         // consecutive automatic restarts
+	restartTimer  *time.Timer // pending automatic restart
+
+	updateProbation time.Duration // 0 if updates are not rolled back automatically
+	probation       *probation    // nil if the service is not on probation
+
+	mu sync.RWMutex // guards cmd, blockedBy, hookFailure, probation, the automatic restart and crash-loop state.
 
 	sync.Mutex // guards Update and Bootstrap functions.
 }
 
 func newService(name string, c *command.Command, r *git.Repo) *Service {
-	svc := &Service{Name: name, cmd: c, repo: r}
-	svc.finished = make(chan error, 1)
-	c.Finish = svc.finished
+	svc := &Service{
+		Name:    name,
+		cmd:     c,
+		repo:    r,
+		history: newServiceHistory(),
+		metrics: procstat.NewHistory(procstat.DefaultHistorySize),
+	}
 
 	return svc
 }
 
 // MarshalJSON implements json marshal interface
 func (s *Service) MarshalJSON() ([]byte, error) {
+	cmd := s.command()
 	return json.Marshal(struct {
-		Name        string         `json:"name"`
-		Version     string         `json:"version"`
-		Status      command.Status `json:"status"`
-		RepoVersion string         `json:"repo_version"`
+		Name        string             `json:"name"`
+		Version     string             `json:"version"`
+		Status      command.Status     `json:"status"`
+		RepoVersion string             `json:"repo_version"`
+		RepoRef     git.RefState       `json:"repo_ref"`
+		BlockedBy   string             `json:"blocked_by,omitempty"`
+		WorkingDir  string             `json:"working_dir,omitempty"`
+		Env         map[string]string  `json:"env,omitempty"`
+		HookError   *hookFailure       `json:"hook_error,omitempty"`
+		Probes      *serviceProbes     `json:"probes,omitempty"`
+		Watchdog    *watchdog.Watchdog `json:"watchdog,omitempty"`
+		Probation   *probation         `json:"probation,omitempty"`
+		Metrics     *procstat.Sample   `json:"metrics,omitempty"`
+		History     *serviceHistory    `json:"history"`
 	}{
 		Name:        s.Name,
-		Version:     s.cmd.Version,
-		Status:      s.cmd.Status(),
+		Version:     cmd.Version,
+		Status:      cmd.Status(),
 		RepoVersion: s.repo.CurrentHead(),
+		RepoRef:     s.repo.CurrentRef(),
+		BlockedBy:   s.blocker(),
+		WorkingDir:  cmd.Cmd.Dir,
+		Env:         s.env.redacted(),
+		HookError:   s.lastHookFailure(),
+		Probes:      s.probes(),
+		Watchdog:    s.watchdog,
+		Probation:   s.currentProbation(),
+		Metrics:     s.metrics.Last(),
+		History:     s.history,
 	})
 }
 
-// Start runs the service observe function in background and then proxies the
-// Start function call to its internal command struct.
+// serviceProbes represents the service probes results.
+type serviceProbes struct {
+	Liveness  *probe.Probe `json:"liveness,omitempty"`
+	Readiness *probe.Probe `json:"readiness,omitempty"`
+}
+
+func (s *Service) probes() *serviceProbes {
+	if s.liveness == nil && s.readiness == nil {
+		return nil
+	}
+
+	return &serviceProbes{Liveness: s.liveness, Readiness: s.readiness}
+}
+
+// ready returns true if the service has no readiness probe or if its last
+// check succeeded.
+func (s *Service) ready() bool {
+	return s.readiness == nil || s.readiness.Result().Status == probe.StatusSuccess
+}
+
+// startProbes starts checking the service health.
+func (s *Service) startProbes() {
+	for _, p := range []*probe.Probe{s.liveness, s.readiness} {
+		if p != nil {
+			p.Start()
+		}
+	}
+}
+
+// stopProbes stops checking the service health.
+func (s *Service) stopProbes() {
+	for _, p := range []*probe.Probe{s.liveness, s.readiness} {
+		if p != nil {
+			p.Stop()
+		}
+	}
+}
+
+// probeCommand returns the process of an exec probe, which runs like the
+// service hooks.
+func (s *Service) probeCommand(name string, args ...string) *exec.Cmd {
+	return s.command().Exec(name, args...)
+}
+
+// livenessFailed restarts the service through its store, since it's running
+// but not working.
+func (s *Service) livenessFailed() {
+	if s.failProbation("liveness probe failed") {
+		return
+	}
+
+	s.logger().Warn("Liveness probe failed, restarting")
+	if s.store == nil {
+		return
+	}
+
+	go func() {
+		if err := s.store.RestartService(s.Name); err != nil {
+			s.logger().Error(err)
+		}
+	}()
+}
+
+// command returns the current service command.
+func (s *Service) command() *command.Command {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.cmd
+}
+
+// renew replaces the service command with a clone of it, so the service can be
+// started again after its command exited.
+func (s *Service) renew() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.cmd = s.cmd.Clone()
+}
+
+// Start proxies the Start function call to its internal command struct and
+// then runs the service observe function in background.
 func (s *Service) Start() error {
-	go s.observe()
-	return s.cmd.Start()
+	s.cancelRestart()
+
+	cmd := s.command()
+	var env []string // nil inherits the operator environment
+	if s.env != nil {
+		var err error
+		env, err = s.env.resolve()
+		if err != nil {
+			cmd.SetStatus(command.StatusBootingError)
+			s.history.record(command.StatusBootingError)
+			return err
+		}
+	}
+
+	// the environment is built from scratch, since cloned commands keep the
+	// previous one along with its watchdog variables.
+	if s.watchdog != nil {
+		if env == nil {
+			env = os.Environ()
+		}
+		env = append(env, s.watchdog.Env()...)
+	}
+
+	if env != nil {
+		cmd.SetEnv(env)
+	}
+
+	s.mu.Lock()
+	s.hookFailure = nil
+	s.mu.Unlock()
+
+	// the service is not started if a pre-start hook fails, the hook output is
+	// reported in the service json.
+	if err := s.runHooks(hookPreStart, s.hooks.preStart); err != nil {
+		cmd.SetStatus(command.StatusBootingError)
+		s.history.record(command.StatusBootingError)
+		return err
+	}
+
+	// the process is created in the service cgroup, so neither it nor its
+	// children ever run without the limits.
+	if s.cgroup != nil {
+		if dir, err := s.cgroup.Open(); err == cgroup.ErrUnsupported {
+			s.logger().Debug("Cgroup v2 not available, running without resource limits")
+		} else if err != nil {
+			s.logger().WithField("error", err).Warn("Could not limit service resources")
+		} else {
+			defer dir.Close()
+			cmd.SetCgroupFD(int(dir.Fd()))
+		}
+	}
+
+	// every start has its own finish channel, so the observer only gets the
+	// exit of the command it watches.
+	finished := make(chan error, 1)
+	cmd.Finish = finished
+
+	// the watchdog starts before the command, so the heartbeat socket exists
+	// when the service starts.
+	s.startWatchdog()
+	if err := cmd.Start(); err != nil {
+		s.stopWatchdog()
+		s.history.record(cmd.Status())
+		return err
+	}
+
+	s.mu.Lock()
+	s.startedAt = time.Now()
+	s.blockedBy = ""
+	s.mu.Unlock()
+	s.history.started()
+
+	s.startProbes()
+	go s.observe(cmd, finished)
+
+	// a failed post-start hook is reported, but the service keeps running.
+	s.runHooks(hookPostStart, s.hooks.postStart)
+	return nil
 }
 
-// Stop proxies function to the its command.
+// Stop proxies function to the its command. It also cancels any pending
+// automatic restart and update probation, and stops the service probes and
+// watchdog. The post-stop hooks run once the command exited, if its process
+// was alive, so they also run when the service is restarted by an update or a
+// rollback.
 func (s *Service) Stop() error {
-	return s.cmd.Stop()
+	s.cancelRestart()
+	s.cancelProbation()
+	s.stopProbes()
+	s.stopWatchdog()
+
+	cmd := s.command()
+	alive := cmd.Alive()
+	if err := cmd.Stop(); err != nil {
+		return err
+	}
+
+	if alive {
+		s.runHooks(hookPostStop, s.hooks.postStop)
+	}
+
+	return nil
 }
 
-// Update proxies function to the its command.
-func (s *Service) Update() (bool, error) {
+// Update proxies function to the its command. The trigger is recorded in the
+// repo deployment history.
+func (s *Service) Update(trigger string) (bool, error) {
+	return s.updateWith(command.UpdaterFunc(func() (string, error) {
+		return s.repo.UpdateBy(trigger)
+	}))
+}
+
+// UpdateFromFile updates the service repo from a git bundle or an archive,
+// without network access.
+func (s *Service) UpdateFromFile(path, checksum, trigger string) (bool, error) {
+	return s.updateWith(command.UpdaterFunc(func() (string, error) {
+		return s.repo.UpdateFromFile(path, checksum, trigger)
+	}))
+}
+
+// Rollback checks out an earlier revision of the service repo, the previous
+// deployment if the sha is empty.
+func (s *Service) Rollback(sha, trigger string) (bool, error) {
+	return s.updateWith(command.UpdaterFunc(func() (string, error) {
+		return s.repo.Rollback(sha, trigger)
+	}))
+}
+
+func (s *Service) updateWith(updater command.Updater) (bool, error) {
 	s.Lock()
 	defer s.Unlock()
 
-	s.cmd.SetStatus(command.StatusUpdating)
-	return s.cmd.Update(s.repo)
+	cmd := s.command()
+	cmd.SetStatus(command.StatusUpdating)
+	s.history.record(command.StatusUpdating)
+	return cmd.Update(updater)
+}
+
+// restoreStatus sets the command status back after an update.
+func (s *Service) restoreStatus(status command.Status) {
+	s.command().SetStatus(status)
+	s.history.record(s.command().Status())
 }
 
 // Bootstrap proxies function to the its repo.
@@ -82,23 +347,51 @@ func (s *Service) Bootstrap(update bool) error {
 		return err
 	}
 
-	s.cmd.Version = s.repo.CurrentHead()
+	s.command().Version = s.repo.CurrentHead()
 
 	return nil
 }
 
-// observe observes if the internal service command exited with error or not.
-// If the command exited with error, it notifies the led service.
-func (s *Service) observe() {
+// observe observes if the given service command exited with error or not.
+// If the command exited with error, it notifies the led service. Then, it
+// restarts the service if its restart policy says so, unless the service is
+// in a crash-loop. The exit of a command that was already replaced by a new
+// one is ignored, since it was stopped on purpose and the new command has its
+// own observer.
+func (s *Service) observe(cmd *command.Command, finished <-chan error) {
 	log := s.logger()
 	log.Info("Start observing")
 	running := true
 	for running {
 		select {
-		case err := <-s.finished:
-			if err != nil {
-				log.Debugf("Service exited with error: %s\n", err.Error())
-				go notifyServiceExitErrorWithRetry(s)
+		case err := <-finished:
+			if s.command() != cmd {
+				log.Info("Replaced command exited")
+				running = false
+				continue
+			}
+			s.stopProbes()
+			s.stopWatchdog()
+			s.detectOOMKill(cmd)
+			s.history.exited(cmd)
+			// services stopped on purpose run their post-stop hooks in Stop.
+			if cmd.Status() != command.StatusStopped {
+				s.runHooks(hookPostStop, s.hooks.postStop)
+			}
+			// updated services that exit on probation are rolled back
+			// instead of restarted.
+			if status := cmd.Status(); status != command.StatusStopped && s.failProbation(fmt.Sprintf("service exited with status %s", status)) {
+				running = false
+				continue
+			}
+			if s.detectCrashLoop(cmd) {
+				s.quarantine()
+			} else {
+				if err != nil {
+					log.Debugf("Service exited with error: %s\n", err.Error())
+					go notifyServiceExitErrorWithRetry(s)
+				}
+				s.scheduleRestart(err)
 			}
 			running = false
 		}
@@ -106,6 +399,137 @@ func (s *Service) observe() {
 	log.Info("Stop observing")
 }
 
+// detectOOMKill sets the oom-killed status if the service command exited
+// because it ran out of memory.
+func (s *Service) detectOOMKill(cmd *command.Command) {
+	if s.cgroup == nil || cmd.Status() == command.StatusStopped {
+		return
+	}
+
+	if s.cgroup.OOMKilled() {
+		cmd.SetStatus(command.StatusOOMKilled)
+		s.logger().Warn("Service killed by the OOM killer")
+	}
+}
+
+// detectCrashLoop records the command exit and returns true if the service
+// exited too many times in a short period. Services stopped on purpose are
+// not taken into account.
+func (s *Service) detectCrashLoop(cmd *command.Command) bool {
+	if cmd.Status() == command.StatusStopped {
+		return false
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.crashLoop == nil {
+		return false
+	}
+
+	return s.crashLoop.exited(time.Now())
+}
+
+// quarantine puts the service command in crash-loop status, so the service is
+// not restarted until someone clears it. It also notifies the led service and
+// publishes a crash-loop event.
+func (s *Service) quarantine() {
+	s.command().SetStatus(command.StatusCrashLoop)
+	s.history.record(command.StatusCrashLoop)
+	s.logger().Warn("Service in crash-loop, quarantined")
+
+	go notifyServiceExitErrorWithRetry(s)
+	go publishEvent(eventServiceCrashLoop, s)
+}
+
+// block puts the service command in blocked status because the required unit
+// is not up, and publishes a blocked event. The service is not started until
+// someone starts it.
+func (s *Service) block(unit string) {
+	s.mu.Lock()
+	s.blockedBy = unit
+	s.mu.Unlock()
+
+	s.command().SetStatus(command.StatusBlocked)
+	s.history.record(command.StatusBlocked)
+	s.logger().WithField("blocked_by", unit).Warn("Required unit is not up, service blocked")
+
+	go publishEvent(eventServiceBlocked, s)
+}
+
+// blocker returns the required unit that blocked the service, if any.
+func (s *Service) blocker() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.blockedBy
+}
+
+// clearQuarantine forgets the service exits and automatic restarts, so the
+// service can be started again.
+func (s *Service) clearQuarantine() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.crashLoop != nil {
+		s.crashLoop.reset()
+	}
+	s.retries = 0
+}
+
+// scheduleRestart restarts the service through its store after the restart
+// policy backoff. Services stopped on purpose are never restarted.
+func (s *Service) scheduleRestart(exitErr error) {
+	log := s.logger()
+
+	if s.command().Status() == command.StatusStopped {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rp := s.restartPolicy
+	if !rp.shouldRestart(exitErr) || s.store == nil {
+		return
+	}
+
+	if time.Since(s.startedAt) >= rp.resetAfter {
+		s.retries = 0
+	}
+
+	if rp.exhausted(s.retries) {
+		log.WithField("retries", s.retries).Warn("Max automatic restarts reached, giving up")
+		return
+	}
+
+	delay := rp.delay(s.retries)
+	s.retries++
+
+	log.WithFields(logrus.Fields{
+		"retry": s.retries,
+		"delay": delay.String(),
+	}).Info("Scheduling automatic restart")
+
+	store, name := s.store, s.Name
+	s.restartTimer = time.AfterFunc(delay, func() {
+		if err := store.StartService(name); err != nil {
+			log.Error(err)
+		}
+	})
+}
+
+// cancelRestart stops the pending automatic restart if exists.
+func (s *Service) cancelRestart() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.restartTimer != nil {
+		s.restartTimer.Stop()
+		s.restartTimer = nil
+	}
+}
+
 // notifyInternetWithRetry calls led.PostServiceExitError until exits without
 // error. The retry interval is 3 seconds.
 func notifyServiceExitErrorWithRetry(s *Service) {
@@ -154,10 +578,11 @@ func (ss *ServiceStore) MarshalJSON() ([]byte, error) {
 }
 
 func (s *Service) logger() *logrus.Entry {
+	cmd := s.command()
 	return logger.GetLogger().WithFields(logrus.Fields{
 		"name":            s.Name,
-		"command_version": s.cmd.Version,
-		"status":          s.cmd.Status(),
+		"command_version": cmd.Version,
+		"status":          cmd.Status(),
 		"repo_version":    s.repo.CurrentHead(),
 	})
 }
@@ -172,65 +597,137 @@ func (ss *ServiceStore) Find(name string) (svc *Service, ok bool) {
 	return svc, ok
 }
 
-// Bootstrap loops each service in the list and calls the bootstrap function.
-func (ss *ServiceStore) Bootstrap(update bool) error {
+// List returns the services in the store.
+func (ss *ServiceStore) List() []*Service {
 	ss.mu.RLock()
 	defer ss.mu.RUnlock()
 
+	svcs := make([]*Service, 0, len(ss.list))
 	for _, svc := range ss.list {
-		if err := svc.Bootstrap(update); err != nil {
-			return err
-		}
+		svcs = append(svcs, svc)
 	}
 
-	return nil
+	return svcs
 }
 
 // Update search the given command in the map and runs its Update function. If
 // the command is not found, an error is returned.
-func (ss *ServiceStore) Update(name string) error {
+func (ss *ServiceStore) Update(name, trigger string) error {
+	return ss.update(name, func(svc *Service) (bool, error) {
+		return svc.Update(trigger)
+	})
+}
+
+// UpdateFromFile updates a specific service repo from a git bundle or an
+// archive, and restarts the service like Update. If the service is not found in
+// the list, it returns an error.
+func (ss *ServiceStore) UpdateFromFile(name, path, checksum, trigger string) error {
+	return ss.update(name, func(svc *Service) (bool, error) {
+		return svc.UpdateFromFile(path, checksum, trigger)
+	})
+}
+
+func (ss *ServiceStore) update(name string, update func(*Service) (bool, error)) error {
 	svc, ok := ss.Find(name)
 
 	if !ok {
 		return fmt.Errorf("services: service with name %q not found", name)
 	}
 
-	cmd := svc.cmd
-
 	svc.logger().Info("Running update")
-	oldStatus := svc.cmd.Status()
-	updated, err := svc.Update()
+	oldStatus := svc.command().Status()
+	oldVersion := svc.repo.CurrentHead()
+	updated, err := update(svc)
 	if err != nil {
 		svc.logger().Debug("Error when updating")
-		svc.cmd.SetStatus(oldStatus)
+		svc.restoreStatus(oldStatus)
 		return err
 	}
 
 	if !updated {
 		svc.logger().Info("No new updates")
-		svc.cmd.SetStatus(oldStatus)
+		svc.restoreStatus(oldStatus)
+		return nil
+	}
+
+	// quarantined services keep the new code but must be cleared before
+	// running again. Blocked services also keep it until they are started.
+	if oldStatus == command.StatusCrashLoop || oldStatus == command.StatusBlocked {
+		svc.logger().WithField("status", oldStatus).Info("Update applied, service remains unstarted")
+		svc.restoreStatus(oldStatus)
 		return nil
 	}
 
 	svc.logger().Info("Update found, stopping")
-	if err := cmd.Stop(); err != nil {
+	return ss.restartUpdated(svc, oldVersion)
+}
+
+// Rollback checks out an earlier revision of a specific service repo, the
+// previous deployment if the sha is empty, and restarts the service. Rolling
+// back clears the crash-loop quarantine, since it's usually the fix, but
+// blocked services remain unstarted until their dependencies are running.
+func (ss *ServiceStore) Rollback(name, sha, trigger string) error {
+	svc, ok := ss.Find(name)
+	if !ok {
+		return fmt.Errorf("services: service %q not found for rolling back", name)
+	}
+
+	svc.logger().WithField("version", sha).Info("Running rollback")
+	oldStatus := svc.command().Status()
+	if _, err := svc.Rollback(sha, trigger); err != nil {
+		svc.restoreStatus(oldStatus)
 		return err
 	}
 
-	cmd = cmd.Clone()
-	ss.Save(svc.Name, cmd, svc.repo)
+	if oldStatus == command.StatusBlocked {
+		svc.logger().Info("Rollback applied, service remains blocked")
+		svc.restoreStatus(oldStatus)
+		return nil
+	}
+
+	if oldStatus == command.StatusCrashLoop {
+		svc.clearQuarantine()
+	}
+
+	svc.logger().Info("Rollback applied, stopping")
+	return ss.restartUpdated(svc, "")
+}
+
+// restartUpdated restarts a service whose code was just updated. If the
+// previous version is given, the service is put on probation, so the update is
+// rolled back if the service can't be started.
+func (ss *ServiceStore) restartUpdated(svc *Service, previousVersion string) error {
+	if err := svc.Stop(); err != nil {
+		return err
+	}
+
+	svc.renew()
+	svc.startProbation(previousVersion)
 
 	svc.logger().Info("Starting updated service")
-	if err := cmd.Start(); err != nil {
+	if err := svc.Start(); err != nil {
+		svc.failProbation(fmt.Sprintf("service could not be started: %s", err.Error()))
 		return err
 	}
 
 	return nil
 }
 
+// Deployments returns the deployment history of a specific service repo. If
+// the service is not found in the list, it returns an error.
+func (ss *ServiceStore) Deployments(name string) ([]git.Deployment, error) {
+	svc, ok := ss.Find(name)
+	if !ok {
+		return nil, fmt.Errorf("services: service %q not found", name)
+	}
+
+	return svc.repo.Deployments()
+}
+
 // Save builds and add the service to the list.
 func (ss *ServiceStore) Save(name string, c *command.Command, r *git.Repo) *Service {
 	s := newService(name, c, r)
+	s.store = ss
 
 	ss.mu.RLock()
 	if ss.list == nil {
@@ -246,6 +743,29 @@ func (ss *ServiceStore) Save(name string, c *command.Command, r *git.Repo) *Serv
 	return s
 }
 
+// Remove deletes the service from the list, cancels its pending automatic
+// restart and closes its output file. The service must be stopped before
+// removing it.
+func (ss *ServiceStore) Remove(name string) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	if svc, ok := ss.list[name]; ok {
+		svc.cancelRestart()
+		svc.stopProbes()
+		svc.stopWatchdog()
+		if svc.logFile != nil {
+			svc.logFile.Close()
+		}
+		if svc.cgroup != nil {
+			if err := svc.cgroup.Remove(); err != nil && err != cgroup.ErrUnsupported {
+				svc.logger().WithField("error", err).Warn("Could not remove service cgroup")
+			}
+		}
+	}
+	delete(ss.list, name)
+}
+
 // StartService starts a specific service inside the store. If the service is
 // not found in the list, it returns an error.
 func (ss *ServiceStore) StartService(name string) error {
@@ -254,30 +774,71 @@ func (ss *ServiceStore) StartService(name string) error {
 		return fmt.Errorf("services: service %q not found for starting", name)
 	}
 
-	status := svc.cmd.Status()
-	if status == command.StatusCrashed || status == command.StatusBootingError || status == command.StatusStopped || status == command.StatusDone {
-		newCmd := svc.cmd.Clone()
-		svc = ss.Save(svc.Name, newCmd, svc.repo)
+	status := svc.command().Status()
+	if status == command.StatusCrashLoop {
+		return fmt.Errorf("services: service %q is in crash-loop, it must be cleared before starting it", name)
+	}
+
+	if status == command.StatusCrashed || status == command.StatusBootingError || status == command.StatusStopped || status == command.StatusDone || status == command.StatusBlocked || status == command.StatusOOMKilled {
+		svc.renew()
 	}
 
 	svc.logger().Info("Starting")
 	return svc.Start()
 }
 
-// Start starts all the commands inside the command list by looping and calling
-// each command Start function.
-func (ss *ServiceStore) Start() error {
-	ss.mu.RLock()
-	defer ss.mu.RUnlock()
+// Logs returns the last output lines of a specific service. If the service is
+// not found in the list, it returns an error.
+func (ss *ServiceStore) Logs(name string, lines int) ([]string, error) {
+	svc, ok := ss.Find(name)
+	if !ok {
+		return nil, fmt.Errorf("services: service %q not found", name)
+	}
 
-	for _, svc := range ss.list {
-		svc.logger().Info("Starting")
-		if err := svc.Start(); err != nil {
-			return err
-		}
+	return svc.command().Tail(lines), nil
+}
+
+// History returns the lifecycle history of a specific service. If the service
+// is not found in the list, it returns an error.
+func (ss *ServiceStore) History(name string) (*serviceHistory, error) {
+	svc, ok := ss.Find(name)
+	if !ok {
+		return nil, fmt.Errorf("services: service %q not found", name)
 	}
 
-	return nil
+	return svc.history, nil
+}
+
+// Metrics returns the last resource usage samples of a specific service. If
+// the service is not found in the list, it returns an error.
+func (ss *ServiceStore) Metrics(name string) (*procstat.History, error) {
+	svc, ok := ss.Find(name)
+	if !ok {
+		return nil, fmt.Errorf("services: service %q not found", name)
+	}
+
+	return svc.metrics, nil
+}
+
+// ClearService takes a specific service out of the crash-loop quarantine and
+// starts it again. If the service is not found in the list or it is not in
+// crash-loop, it returns an error.
+func (ss *ServiceStore) ClearService(name string) error {
+	svc, ok := ss.Find(name)
+	if !ok {
+		return fmt.Errorf("services: service %q not found for clearing", name)
+	}
+
+	if svc.command().Status() != command.StatusCrashLoop {
+		return fmt.Errorf("services: service %q is not in crash-loop", name)
+	}
+
+	svc.logger().Info("Clearing crash-loop")
+	svc.clearQuarantine()
+	svc.renew()
+
+	svc.logger().Info("Starting")
+	return svc.Start()
 }
 
 // StopService stops a specific service inside the store. If the service is not
@@ -289,8 +850,12 @@ func (ss *ServiceStore) StopService(name string) error {
 	}
 
 	svc.logger().Info("Stopping")
-	defer svc.logger().Info("Stopped")
-	return svc.Stop()
+	if err := svc.Stop(); err != nil {
+		return err
+	}
+
+	svc.logger().WithField("signal", svc.command().StopSignal()).Info("Stopped")
+	return nil
 }
 
 // RestartService restarts a specific service inside the store. If the service is not
@@ -302,30 +867,17 @@ func (ss *ServiceStore) RestartService(name string) error {
 		return fmt.Errorf("services: service with name %q not found", name)
 	}
 
+	if svc.command().Status() == command.StatusCrashLoop {
+		return fmt.Errorf("services: service %q is in crash-loop, it must be cleared before restarting it", name)
+	}
+
 	svc.logger().Info("Restarting")
 	if err := svc.Stop(); err != nil {
 		return err
 	}
 
-	cmd := svc.cmd.Clone()
-	ss.Save(svc.Name, cmd, svc.repo)
+	svc.renew()
 
 	defer svc.logger().Info("Restarted")
-	return cmd.Start()
-}
-
-// Stop stops all the services inside the store by looping and calling each
-// service command's Stop function.
-func (ss *ServiceStore) Stop() error {
-	ss.mu.RLock()
-	defer ss.mu.RUnlock()
-
-	for _, svc := range ss.list {
-		svc.logger().Info("Stopping")
-		if err := svc.Stop(); err != nil {
-			return err
-		}
-	}
-
-	return nil
+	return svc.Start()
 }
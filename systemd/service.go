@@ -65,15 +65,31 @@ func Status(name string) (ServiceStatus, error) {
 
 // Start starts the service by telling systemd to start it.
 func Start(name string) error {
-	return exec.Command("sudo", "systemctl", "start", name).Run()
+	return exec.Command("systemctl", "start", name).Run()
 }
 
 // Restart restarts the service by telling systemd to restart it.
 func Restart(name string) error {
-	return exec.Command("sudo", "systemctl", "restart", name).Run()
+	return exec.Command("systemctl", "restart", name).Run()
 }
 
 // Stop stops the service by telling systemd to stop it.
 func Stop(name string) error {
-	return exec.Command("sudo", "systemctl", "stop", name).Run()
+	return exec.Command("systemctl", "stop", name).Run()
+}
+
+// MainPID returns the pid of the service main process by asking systemd. It
+// returns 0 if the service is not running.
+func MainPID(name string) (int, error) {
+	out, err := exec.Command("systemctl", "show", "--property", "MainPID", name).Output()
+	if err != nil {
+		return 0, err
+	}
+
+	var pid int
+	if _, err := fmt.Sscanf(string(bytes.TrimSpace(out)), "MainPID=%d", &pid); err != nil {
+		return 0, fmt.Errorf("unknown main pid received from systemd: %q", out)
+	}
+
+	return pid, nil
 }
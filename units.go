@@ -0,0 +1,875 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/WiseGrowth/go-wisebot/config"
+	"github.com/WiseGrowth/go-wisebot/logger"
+	"github.com/WiseGrowth/go-wisebot/rasp"
+	"github.com/WiseGrowth/wisebot-operator/cgroup"
+	"github.com/WiseGrowth/wisebot-operator/command"
+	"github.com/WiseGrowth/wisebot-operator/cron"
+	"github.com/WiseGrowth/wisebot-operator/daemon"
+	"github.com/WiseGrowth/wisebot-operator/git"
+	"github.com/WiseGrowth/wisebot-operator/logfile"
+	"github.com/WiseGrowth/wisebot-operator/manifest"
+	"github.com/WiseGrowth/wisebot-operator/probe"
+	"github.com/WiseGrowth/wisebot-operator/watchdog"
+	homedir "github.com/mitchellh/go-homedir"
+)
+
+const (
+	wisebotUnitsPath       = "~/.config/wisebot/units.json"
+	wisebotServiceLogsPath = "~/.wisebot/logs"
+
+	defaultBranchName = "master"
+
+	// wisebotUser owns the wisebot files: the config, the logs and the units
+	// repos. `~` expands to its home, so the paths stay the same when the
+	// operator runs as root.
+	wisebotUser = "pi"
+
+	defaultLogMaxSizeMB = 10
+	defaultLogMaxFiles  = 3
+)
+
+// loadManifest reads the units manifest. If the manifest file does not exist,
+// it falls back to the units that every wisebot ships with.
+func loadManifest() (*manifest.Manifest, error) {
+	path, err := expandHome(wisebotUnitsPath)
+	if err != nil {
+		return nil, err
+	}
+
+	m, err := manifest.Load(path)
+	if os.IsNotExist(err) {
+		logger.GetLogger().WithField("path", path).Info("Units manifest not found, using default units")
+		return defaultManifest(wisebotConfig), nil
+	}
+
+	return m, err
+}
+
+// UnitLoader fills the service, daemon and job stores with the manifest units. It
+// keeps the loaded manifest in order to diff it against a new one when the
+// units are reloaded.
+type UnitLoader struct {
+	sync.Mutex
+	Services *ServiceStore
+	Daemons  *daemon.Store
+	Jobs     *JobStore
+
+	manifest *manifest.Manifest
+	order    []manifest.Unit      // manifest units in dependency order
+	repos    map[string]*git.Repo // indexed by expanded repo path
+	stopped  bool                 // true once the units were stopped for good
+}
+
+// unitBuild holds the initialized components of a manifest unit.
+type unitBuild struct {
+	unit       manifest.Unit
+	repo       *git.Repo
+	cmd        *command.Command
+	logFile    *logfile.Writer
+	restart    restartPolicy
+	crashLoop  *crashLoopDetector
+	liveness   *probe.Probe
+	readiness  *probe.Probe
+	watchdog   *watchdog.Watchdog
+	env        *serviceEnv
+	cgroup     *cgroup.Group
+	hooks      serviceHooks
+	schedule   *cron.Schedule
+	privileges *command.Privileges
+	daemon     daemon.Daemon
+}
+
+// close releases the resources opened while building the unit. It must be
+// called if the build is discarded.
+func (b *unitBuild) close() {
+	if b.logFile != nil {
+		b.logFile.Close()
+	}
+}
+
+// closeBuilds closes every given build.
+func closeBuilds(builds []*unitBuild) {
+	for _, b := range builds {
+		b.close()
+	}
+}
+
+// Load builds the manifest units and saves them into its store.
+func (ul *UnitLoader) Load(m *manifest.Manifest) error {
+	ul.Lock()
+	defer ul.Unlock()
+
+	order, err := m.Order()
+	if err != nil {
+		return err
+	}
+
+	repos := make(map[string]*git.Repo)
+	builds := make([]*unitBuild, 0, len(m.Units))
+	for _, u := range order {
+		b, err := buildUnit(u, repos)
+		if err != nil {
+			closeBuilds(builds)
+			return err
+		}
+		builds = append(builds, b)
+	}
+
+	for _, b := range builds {
+		ul.save(b)
+	}
+
+	ul.manifest = m
+	ul.order = order
+	ul.repos = repos
+
+	return nil
+}
+
+// Reload reads the manifest again and diffs it against the loaded one. Added
+// units are started, removed units are stopped and changed units are
+// restarted, following the dependency order. Units whose definition did not
+// change are not touched. The update param indicates if the new units source
+// code can be updated or not.
+func (ul *UnitLoader) Reload(update bool) error {
+	ul.Lock()
+	defer ul.Unlock()
+
+	log := logger.GetLogger()
+	log.Info("Reloading units")
+
+	m, err := loadManifest()
+	if err != nil {
+		return err
+	}
+
+	order, err := m.Order()
+	if err != nil {
+		return err
+	}
+
+	// Units that did not change keep their repos, so new units that share the
+	// same repo path also share the repository.
+	repos := make(map[string]*git.Repo)
+	for _, u := range m.Units {
+		old, ok := ul.manifest.Find(u.Name)
+		if !ok || !reflect.DeepEqual(old, u) || u.Repo == nil {
+			continue
+		}
+
+		repoPath, err := expandHome(u.Repo.Path)
+		if err != nil {
+			return err
+		}
+		repos[repoPath] = ul.repos[repoPath]
+	}
+
+	// Build every added or changed unit before touching the running ones, so
+	// an invalid manifest does not disturb the device.
+	var builds []*unitBuild
+	existed := make(map[string]bool)
+	for _, u := range order {
+		old, ok := ul.manifest.Find(u.Name)
+		if ok && reflect.DeepEqual(old, u) {
+			continue
+		}
+
+		b, err := buildUnit(u, repos)
+		if err != nil {
+			closeBuilds(builds)
+			return err
+		}
+		builds = append(builds, b)
+		existed[u.Name] = ok
+	}
+
+	// units are removed in reverse dependency order, so dependents are
+	// stopped first.
+	for i := len(ul.order) - 1; i >= 0; i-- {
+		old := ul.order[i]
+		u, ok := m.Find(old.Name)
+		if ok && reflect.DeepEqual(old, u) {
+			continue
+		}
+
+		if !ok {
+			log.WithField("name", old.Name).Info("Unit removed")
+		}
+		ul.remove(old, ok)
+	}
+
+	// the new manifest is needed to check the required units when starting.
+	ul.manifest = m
+	ul.order = order
+	ul.repos = repos
+
+	var errs []string
+	for _, b := range builds {
+		ul.save(b)
+
+		if err := ul.start(b, update, existed[b.unit.Name]); err != nil {
+			log.WithField("name", b.unit.Name).Error(err)
+			errs = append(errs, fmt.Sprintf("%s: %s", b.unit.Name, err.Error()))
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("units: reload failed for %s", strings.Join(errs, ", "))
+	}
+
+	log.Info("Units reloaded")
+	return nil
+}
+
+// save adds the built unit to its store.
+func (ul *UnitLoader) save(b *unitBuild) {
+	switch b.unit.Kind {
+	case manifest.KindService:
+		svc := ul.Services.Save(b.unit.Name, b.cmd, b.repo)
+		svc.logFile = b.logFile
+		svc.restartPolicy = b.restart
+		svc.updateProbation = b.unit.UpdateProbation.Duration
+		svc.crashLoop = b.crashLoop
+		svc.readiness = b.readiness
+		svc.env = b.env
+		svc.cgroup = b.cgroup
+		svc.hooks = b.hooks
+		svc.liveness = b.liveness
+		if svc.liveness != nil {
+			svc.liveness.OnFailure = svc.livenessFailed
+		}
+		for _, p := range []*probe.Probe{svc.liveness, svc.readiness} {
+			if p == nil {
+				continue
+			}
+			if c, ok := p.Checker.(*probe.ExecChecker); ok {
+				c.Command = svc.probeCommand
+			}
+		}
+		svc.watchdog = b.watchdog
+		if svc.watchdog != nil {
+			svc.watchdog.OnTimeout = svc.watchdogTimeout
+		}
+	case manifest.KindDaemon:
+		if b.daemon != nil {
+			ul.Daemons.Save(b.daemon)
+		}
+	case manifest.KindJob:
+		ul.Jobs.Save(&Job{
+			Name:     b.unit.Name,
+			cmd:      b.cmd,
+			repo:     b.repo,
+			logFile:  b.logFile,
+			schedule: b.schedule,
+			onBoot:   b.unit.OnBoot,
+			timeout:  b.unit.Timeout.Duration,
+			env:      b.env,
+		})
+	}
+}
+
+// remove takes the unit out of its store. Services and jobs are stopped before
+// being removed. Daemons are only stopped if the unit is not going to be
+// replaced, since replaced daemons are restarted afterwards.
+func (ul *UnitLoader) remove(u manifest.Unit, replaced bool) {
+	log := logger.GetLogger().WithField("name", u.Name)
+
+	switch u.Kind {
+	case manifest.KindService:
+		svc, ok := ul.Services.Find(u.Name)
+		if !ok {
+			return
+		}
+
+		if svc.command().Status() == command.StatusRunning {
+			if err := ul.Services.StopService(u.Name); err != nil {
+				log.Error(err)
+			}
+		}
+		ul.Services.Remove(u.Name)
+	case manifest.KindDaemon:
+		if _, ok := ul.Daemons.Find(u.Name); !ok {
+			return
+		}
+
+		if !replaced {
+			if err := ul.Daemons.StopDaemon(u.Name); err != nil {
+				log.Error(err)
+			}
+		}
+		ul.Daemons.Remove(u.Name)
+	case manifest.KindJob:
+		ul.Jobs.Remove(u.Name)
+	}
+}
+
+// start bootstraps and starts the built unit. Services wait for their required
+// units. Daemons that already existed are restarted instead. Jobs are only
+// scheduled, they don't run on boot since the operator is not booting.
+func (ul *UnitLoader) start(b *unitBuild, update bool, existed bool) error {
+	switch b.unit.Kind {
+	case manifest.KindService:
+		svc, _ := ul.Services.Find(b.unit.Name)
+		if err := svc.Bootstrap(update); err != nil {
+			return err
+		}
+
+		return ul.startService(b.unit)
+	case manifest.KindDaemon:
+		if b.daemon == nil {
+			return nil
+		}
+
+		if err := b.daemon.Bootstrap(update); err != nil {
+			return err
+		}
+
+		if existed {
+			return ul.Daemons.RestartDaemon(b.unit.Name)
+		}
+		return ul.Daemons.StartDaemon(b.unit.Name)
+	case manifest.KindJob:
+		job, _ := ul.Jobs.Find(b.unit.Name)
+		if err := job.Bootstrap(update); err != nil {
+			return err
+		}
+
+		job.Start(false)
+	}
+
+	return nil
+}
+
+// buildUnit initializes the unit repo and its command or daemon. Daemons are
+// skipped on darwin since there is no systemd.
+func buildUnit(u manifest.Unit, repos map[string]*git.Repo) (*unitBuild, error) {
+	b := &unitBuild{unit: u}
+
+	repo, err := newUnitRepo(u, repos)
+	if err != nil {
+		return nil, err
+	}
+	b.repo = repo
+
+	switch u.Kind {
+	case manifest.KindService:
+		if err := b.buildCommand(); err != nil {
+			return nil, err
+		}
+
+		if u.Probes != nil {
+			b.liveness = newUnitProbe(u.Probes.Liveness)
+			b.readiness = newUnitProbe(u.Probes.Readiness)
+		}
+
+		b.watchdog, err = newUnitWatchdog(u, b.privileges)
+		if err != nil {
+			b.close()
+			return nil, err
+		}
+
+		b.cgroup = newUnitCgroup(u)
+
+		b.hooks, err = newUnitHooks(u)
+		if err != nil {
+			b.close()
+			return nil, err
+		}
+		b.restart = newRestartPolicy(u.Restart)
+		b.crashLoop = newCrashLoopDetector(u.CrashLoop)
+	case manifest.KindJob:
+		if err := b.buildCommand(); err != nil {
+			return nil, err
+		}
+
+		if len(u.Schedule) > 0 {
+			b.schedule, err = cron.Parse(u.Schedule)
+			if err != nil {
+				b.close()
+				return nil, fmt.Errorf("units: job %q: %s", u.Name, err.Error())
+			}
+		}
+	case manifest.KindDaemon:
+		if runtime.GOOS == "darwin" {
+			return b, nil
+		}
+
+		b.daemon, err = daemon.NewDaemon(u.Name, repo)
+		if err != nil {
+			return nil, fmt.Errorf("units: daemon %q: %s", u.Name, err.Error())
+		}
+	}
+
+	return b, nil
+}
+
+// buildCommand initializes the command of a service or a job, along with its
+// output file, environment, working directory and privileges.
+func (b *unitBuild) buildCommand() error {
+	u := b.unit
+
+	var err error
+	b.cmd, err = newUnitCommand(u)
+	if err != nil {
+		return err
+	}
+
+	b.logFile, err = newUnitLogFile(u)
+	if err != nil {
+		return err
+	}
+	b.cmd.SetOutput(b.logFile)
+
+	if u.Log != nil && u.Log.TailLines > 0 {
+		b.cmd.SetTail(command.NewTail(u.Log.TailLines))
+	}
+
+	b.cmd.SetStopTimeout(u.StopTimeout.Duration)
+
+	var usr *user.User
+	if len(u.User) > 0 {
+		usr, err = lookupUser(u.User)
+		if err != nil {
+			b.close()
+			return fmt.Errorf("units: %s %q: %s", u.Kind, u.Name, err.Error())
+		}
+	}
+
+	b.env, err = newUnitEnv(u, usr)
+	if err != nil {
+		b.close()
+		return err
+	}
+
+	// jobs without a code's repository run in the operator working directory
+	// by default. Repos that keep releases run from the current one.
+	var dir string
+	if b.repo != nil {
+		dir = b.repo.CurrentPath()
+	}
+	if len(u.WorkingDir) > 0 {
+		dir, err = expandHome(u.WorkingDir)
+		if err != nil {
+			b.close()
+			return err
+		}
+	}
+	b.cmd.SetDir(dir)
+
+	b.privileges, err = newUnitPrivileges(u, usr)
+	if err != nil {
+		b.close()
+		return err
+	}
+	b.cmd.SetPrivileges(b.privileges)
+
+	return nil
+}
+
+// reloadUnits reloads the units manifest. The units source code is only
+// updated if the device has internet connection.
+func reloadUnits() error {
+	isConnected, err := rasp.IsConnected()
+	if err != nil {
+		return err
+	}
+
+	return unitLoader.Reload(isConnected)
+}
+
+// newUnitRepo initializes the unit repository at the declared ref. Units that
+// share the same repo path also share the same *git.Repo, eg: ssh-tunnel and
+// storage-tunnel, so they must declare the same ref.
+func newUnitRepo(u manifest.Unit, repos map[string]*git.Repo) (*git.Repo, error) {
+	if u.Repo == nil {
+		return nil, nil
+	}
+
+	repoPath, err := expandHome(u.Repo.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	ref, ok := u.Repo.Ref()
+	if !ok {
+		branch := u.Repo.Branch
+		if len(branch) == 0 {
+			branch = defaultBranchName
+		}
+		ref = git.BranchRef(branch)
+	}
+
+	trusted, err := newUnitTrustedKeys(u.Repo.TrustedKeys)
+	if err != nil {
+		return nil, err
+	}
+
+	if r, ok := repos[repoPath]; ok {
+		if r.Remote != u.Repo.Remote || r.Ref != ref || r.Releases != u.Repo.Releases || !reflect.DeepEqual(r.TrustedKeys, trusted) {
+			return nil, fmt.Errorf("units: unit %q declares repo %q with a different remote, ref, releases or trusted keys", u.Name, u.Repo.Path)
+		}
+		return r, nil
+	}
+
+	hooks := make([]git.PostReceiveHook, len(u.Repo.Hooks))
+	for i, h := range u.Repo.Hooks {
+		hook, err := newRepoHook(u, h)
+		if err != nil {
+			return nil, err
+		}
+		hooks[i] = hook
+	}
+
+	r := git.NewRepoAt(repoPath, u.Repo.Remote, ref, hooks...)
+	r.Releases = u.Repo.Releases
+	r.TrustedKeys = trusted
+
+	// git and the hooks run as the wisebot user, so the repo belongs to it
+	// and its ssh keys are used. Devices without it use the operator user.
+	if usr, err := user.Lookup(wisebotUser); err == nil {
+		r.Credential, err = userCredential(usr)
+		if err != nil {
+			return nil, err
+		}
+		r.Env = []string{"HOME=" + usr.HomeDir, "USER=" + usr.Username, "LOGNAME=" + usr.Username}
+	}
+	repos[repoPath] = r
+
+	return r, nil
+}
+
+// newRepoHook returns the post-receive hook preset, or builds the command hook.
+// The hook executable, its arguments and its working dir can reference the
+// home directory using `~`.
+func newRepoHook(u manifest.Unit, h manifest.RepoHook) (git.PostReceiveHook, error) {
+	if len(h.Preset) > 0 {
+		hook, ok := git.PostReceiveHookByName(h.Preset)
+		if !ok {
+			return nil, fmt.Errorf("units: unit %q has unknown post-receive hook %q", u.Name, h.Preset)
+		}
+		return hook, nil
+	}
+
+	exec := make([]string, len(h.Exec))
+	for i, arg := range h.Exec {
+		var err error
+		exec[i], err = expandHome(arg)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	dir, err := expandHome(h.WorkingDir)
+	if err != nil {
+		return nil, err
+	}
+
+	hook := git.CommandHook{
+		Name:    exec[0],
+		Args:    exec[1:],
+		Dir:     dir,
+		Timeout: h.Timeout.Duration,
+		Paths:   h.Paths,
+	}
+
+	// the hook runs with the variables that describe its user, like services.
+	env := make(map[string]string)
+	if len(h.User) > 0 {
+		usr, err := lookupUser(h.User)
+		if err != nil {
+			return nil, fmt.Errorf("units: unit %q post-receive hook user: %s", u.Name, err.Error())
+		}
+
+		hook.Credential, err = userCredential(usr)
+		if err != nil {
+			return nil, err
+		}
+
+		env["HOME"] = usr.HomeDir
+		env["USER"] = usr.Username
+		env["LOGNAME"] = usr.Username
+	}
+	for k, v := range h.Env {
+		env[k] = v
+	}
+
+	keys := make([]string, 0, len(env))
+	for k := range env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		hook.Env = append(hook.Env, fmt.Sprintf("%s=%s", k, env[k]))
+	}
+
+	return hook.PostReceiveHook(), nil
+}
+
+// newUnitTrustedKeys expands the trusted keys paths. It returns nil if the
+// repo has no trusted keys.
+func newUnitTrustedKeys(k *manifest.TrustedKeys) (*git.TrustedKeys, error) {
+	if k == nil {
+		return nil, nil
+	}
+
+	gpgHome, err := expandHome(k.GPGHome)
+	if err != nil {
+		return nil, err
+	}
+
+	signers, err := expandHome(k.SSHAllowedSigners)
+	if err != nil {
+		return nil, err
+	}
+
+	return &git.TrustedKeys{GPGHome: gpgHome, SSHAllowedSigners: signers}, nil
+}
+
+// newUnitCommand initializes the service or job command. The executable and its
+// arguments can reference the home directory using `~`.
+func newUnitCommand(u manifest.Unit) (*command.Command, error) {
+	name, err := expandHome(u.Exec)
+	if err != nil {
+		return nil, err
+	}
+
+	args := make([]string, len(u.Args))
+	for i, arg := range u.Args {
+		args[i], err = expandHome(arg)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return command.NewCommand(name, args...), nil
+}
+
+// newUnitLogFile opens the service or job output file, located in
+// ~/.wisebot/logs/<unit>.log.
+func newUnitLogFile(u manifest.Unit) (*logfile.Writer, error) {
+	logsPath, err := expandHome(wisebotServiceLogsPath)
+	if err != nil {
+		return nil, err
+	}
+
+	maxSizeMB, maxFiles, compress := defaultLogMaxSizeMB, defaultLogMaxFiles, false
+	if u.Log != nil {
+		if u.Log.MaxSizeMB > 0 {
+			maxSizeMB = u.Log.MaxSizeMB
+		}
+		if u.Log.MaxFiles > 0 {
+			maxFiles = u.Log.MaxFiles
+		}
+		compress = u.Log.Compress
+	}
+
+	return logfile.New(
+		filepath.Join(logsPath, u.Name+".log"),
+		int64(maxSizeMB)*1024*1024,
+		maxFiles,
+		compress,
+	)
+}
+
+// newUnitCgroup returns the service cgroup, limited by the manifest
+// resources. It returns nil if the service has no limits, so it runs in the
+// operator cgroup.
+func newUnitCgroup(u manifest.Unit) *cgroup.Group {
+	r := u.Resources
+	if r == nil {
+		return nil
+	}
+
+	l := cgroup.Limits{
+		MemoryMax: int64(r.MemoryMaxMB) * 1024 * 1024,
+		CPUWeight: r.CPUWeight,
+		CPUQuota:  r.CPUQuota,
+		PidsMax:   r.PidsMax,
+	}
+	if l == (cgroup.Limits{}) {
+		return nil
+	}
+
+	return cgroup.New(u.Name, l)
+}
+
+// newUnitProbe builds the probe declared in the manifest. It returns nil if
+// the probe is not declared.
+func newUnitProbe(p *manifest.Probe) *probe.Probe {
+	if p == nil {
+		return nil
+	}
+
+	var checker probe.Checker
+	switch {
+	case len(p.HTTP) > 0:
+		checker = &probe.HTTPChecker{URL: p.HTTP}
+	case len(p.TCP) > 0:
+		checker = &probe.TCPChecker{Address: p.TCP}
+	default:
+		checker = &probe.ExecChecker{Name: p.Exec[0], Args: p.Exec[1:]}
+	}
+
+	pr := probe.New(checker)
+	pr.InitialDelay = p.InitialDelay.Duration
+	if p.Interval.Duration > 0 {
+		pr.Interval = p.Interval.Duration
+	}
+	if p.Timeout.Duration > 0 {
+		pr.Timeout = p.Timeout.Duration
+	}
+	if p.FailureThreshold > 0 {
+		pr.FailureThreshold = p.FailureThreshold
+	}
+
+	return pr
+}
+
+// expandHome expands a leading `~` to the wisebot user home, whatever user
+// the operator and the unit run as.
+func expandHome(s string) (string, error) {
+	if s != "~" && !strings.HasPrefix(s, "~/") {
+		return s, nil
+	}
+
+	home, err := wisebotHome()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(home, s[1:]), nil
+}
+
+// wisebotHome returns the wisebot user home directory, or the operator user
+// one if there is no wisebot user, eg: on development machines.
+func wisebotHome() (string, error) {
+	if usr, err := user.Lookup(wisebotUser); err == nil {
+		return usr.HomeDir, nil
+	}
+
+	return homedir.Dir()
+}
+
+// defaultManifest returns the units that every wisebot ships with. Branches
+// can be overridden through the wisebot config file.
+func defaultManifest(cfg *config.Config) *manifest.Manifest {
+	return &manifest.Manifest{
+		Units: []manifest.Unit{
+			{
+				Name: "wisebot-core",
+				Kind: manifest.KindService,
+				Repo: &manifest.Repo{
+					Path:   "~/wisebot-core",
+					Remote: "git@github.com:wisegrowth/wisebot-core.git",
+					Branch: cfg.CoreBranch,
+					Hooks:  []manifest.RepoHook{{Preset: "yarn-install"}},
+				},
+				Exec:  "node",
+				Args:  []string{"~/wisebot-core/build/app/index.js"},
+				User:  "pi",
+				After: []string{"network-operator", "wisebot-storage"},
+			},
+			{
+				Name: "wisebot-ble",
+				Kind: manifest.KindService,
+				Repo: &manifest.Repo{
+					Path:   "~/wisebot-ble",
+					Remote: "git@github.com:wisegrowth/wisebot-ble.git",
+					Branch: cfg.BleBranch,
+					Hooks:  []manifest.RepoHook{{Preset: "npm-install"}},
+				},
+				Exec: "node",
+				Args: []string{"~/wisebot-ble/build/app/index.js"},
+				User: "pi",
+			},
+			{
+				Name: "wisebot-script",
+				Kind: manifest.KindService,
+				Repo: &manifest.Repo{
+					Path:   "~/wisebot-script",
+					Remote: "git@github.com:wisegrowth/wisebot-script.git",
+					Branch: cfg.ScriptBranch,
+				},
+				Exec: "~/wisebot-script/wisebot-script",
+				// wisebot-script reads the sensors and drives the actuators
+				// through the gpio, i2c and spi devices, and maps /dev/mem,
+				// which belongs to root and the kmem group.
+				User:         "pi",
+				Groups:       []string{"gpio", "i2c", "spi", "kmem"},
+				Capabilities: []string{"CAP_SYS_RAWIO"},
+			},
+			{
+				Name: "wisebot-storage",
+				Kind: manifest.KindService,
+				Repo: &manifest.Repo{
+					Path:   "~/wisebot-storage",
+					Remote: "git@github.com:wisegrowth/wisebot-storage.git",
+					Branch: cfg.StorageBranch,
+				},
+				Exec: "~/wisebot-storage/wisebot-storage",
+				User: "pi",
+			},
+			{
+				Name: "network-operator",
+				Kind: manifest.KindDaemon,
+				Repo: &manifest.Repo{
+					Path:   "~/network-operator",
+					Remote: "git@github.com:wisegrowth/network-operator.git",
+					Branch: cfg.NetworkOperatorBranch,
+				},
+			},
+			{
+				Name: "led",
+				Kind: manifest.KindDaemon,
+				Repo: &manifest.Repo{
+					Path:   "~/wisebot-led-indicator",
+					Remote: "git@github.com:wisegrowth/wisebot-led-indicator.git",
+					Branch: cfg.LedBranch,
+					Hooks:  []manifest.RepoHook{{Preset: "yarn-install"}},
+				},
+			},
+			{
+				Name: "ssh-tunnel",
+				Kind: manifest.KindDaemon,
+				Repo: &manifest.Repo{
+					Path:   "~/wisebot-tunnel",
+					Remote: "git@github.com:wisegrowth/wisebot-tunnel.git",
+					Branch: cfg.TunnelBranch,
+					Hooks:  []manifest.RepoHook{{Preset: "yarn-install"}},
+				},
+			},
+			{
+				Name: "storage-tunnel",
+				Kind: manifest.KindDaemon,
+				Repo: &manifest.Repo{
+					Path:   "~/wisebot-tunnel",
+					Remote: "git@github.com:wisegrowth/wisebot-tunnel.git",
+					Branch: cfg.TunnelBranch,
+					Hooks:  []manifest.RepoHook{{Preset: "yarn-install"}},
+				},
+			},
+			{
+				Name: "wisebot-button",
+				Kind: manifest.KindDaemon,
+				Repo: &manifest.Repo{
+					Path:   "~/wisebot-button",
+					Remote: "git@github.com:wisegrowth/wisebot-button.git",
+					Branch: cfg.ButtonBranch,
+				},
+			},
+		},
+	}
+}
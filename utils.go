@@ -6,12 +6,10 @@ import (
 	"io/ioutil"
 	"net/http"
 	"os"
-
-	homedir "github.com/mitchellh/go-homedir"
 )
 
 func newFile(name string) (*os.File, error) {
-	expanded, err := homedir.Expand(name)
+	expanded, err := expandHome(name)
 	if err != nil {
 		return nil, err
 	}
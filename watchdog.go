@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/WiseGrowth/wisebot-operator/command"
+	"github.com/WiseGrowth/wisebot-operator/manifest"
+	"github.com/WiseGrowth/wisebot-operator/watchdog"
+)
+
+// wisebotWatchdogPath is world-traversable, so services that don't run as the
+// operator user can reach their heartbeat files.
+const wisebotWatchdogPath = "/run/wisebot-operator/watchdog"
+
+// newUnitWatchdog builds the service watchdog declared in the manifest. It
+// returns nil if the watchdog is not declared. The heartbeat files and sockets
+// are located in /run/wisebot-operator/watchdog, and they are owned by the
+// service user.
+func newUnitWatchdog(u manifest.Unit, p *command.Privileges) (*watchdog.Watchdog, error) {
+	if u.Watchdog == nil {
+		return nil, nil
+	}
+
+	w := watchdog.New(u.Watchdog.Kind, u.Watchdog.Interval.Duration)
+	w.InitialDelay = u.Watchdog.InitialDelay.Duration
+	if p != nil && p.Credential != nil {
+		w.UID = int(p.Credential.Uid)
+		w.GID = int(p.Credential.Gid)
+	}
+
+	switch w.Kind {
+	case watchdog.KindHTTP:
+		w.URL = fmt.Sprintf("http://localhost:%d/services/%s/heartbeat", httpPort, u.Name)
+	case watchdog.KindFile, watchdog.KindSocket:
+		if err := os.MkdirAll(wisebotWatchdogPath, 0755); err != nil {
+			return nil, err
+		}
+
+		// the directory may exist from a previous run with other permissions.
+		if err := os.Chmod(wisebotWatchdogPath, 0755); err != nil {
+			return nil, err
+		}
+
+		w.Path = filepath.Join(wisebotWatchdogPath, u.Name)
+		if w.Kind == watchdog.KindSocket {
+			w.Path += ".sock"
+		}
+	}
+
+	return w, nil
+}
+
+// startWatchdog starts waiting for the service heartbeats. A watchdog that
+// can't be started is reported, but the service runs anyway.
+func (s *Service) startWatchdog() {
+	if s.watchdog == nil {
+		return
+	}
+
+	if err := s.watchdog.Start(); err != nil {
+		s.logger().WithField("error", err).Warn("Could not start the service watchdog")
+	}
+}
+
+// stopWatchdog stops waiting for the service heartbeats.
+func (s *Service) stopWatchdog() {
+	if s.watchdog != nil {
+		s.watchdog.Stop()
+	}
+}
+
+// watchdogTimeout restarts the service through its store, since it's running
+// but it stopped sending heartbeats.
+func (s *Service) watchdogTimeout() {
+	if s.failProbation("watchdog timeout") {
+		return
+	}
+
+	s.logger().WithField("interval", s.watchdog.Interval.String()).Warn("Watchdog timeout, no heartbeat received, restarting")
+	if s.store == nil {
+		return
+	}
+
+	go func() {
+		if err := s.store.RestartService(s.Name); err != nil {
+			s.logger().Error(err)
+		}
+	}()
+}
+
+// Heartbeat records a heartbeat of a specific service watchdog. If the service
+// is not found in the list or it has no watchdog, it returns an error.
+func (ss *ServiceStore) Heartbeat(name string) error {
+	svc, ok := ss.Find(name)
+	if !ok {
+		return fmt.Errorf("services: service %q not found", name)
+	}
+
+	if svc.watchdog == nil {
+		return fmt.Errorf("services: service %q has no watchdog", name)
+	}
+
+	return svc.watchdog.Beat()
+}
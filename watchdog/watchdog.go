@@ -0,0 +1,285 @@
+package watchdog
+
+/*
+This package detects services that are running but stuck. The service proves
+it's alive by sending heartbeats: touching a file, writing to a unix datagram
+socket or doing an http request to the operator. If no heartbeat arrives
+within the interval, the watchdog times out.
+*/
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Heartbeat kinds
+const (
+	KindFile   = "file"
+	KindSocket = "socket"
+	KindHTTP   = "http"
+)
+
+// Environment variables given to the watched service, so it knows how often
+// and where to send its heartbeats. The interval is in microseconds, like the
+// systemd WATCHDOG_USEC variable, and the service should send a heartbeat
+// every half interval.
+const (
+	EnvInterval = "WISEBOT_WATCHDOG_USEC"
+	EnvFile     = "WISEBOT_WATCHDOG_FILE"
+	EnvSocket   = "WISEBOT_WATCHDOG_SOCKET"
+	EnvURL      = "WISEBOT_WATCHDOG_URL"
+)
+
+// minPollInterval is the min interval between heartbeat file checks.
+const minPollInterval = 100 * time.Millisecond
+
+// Status represents the watchdog state.
+type Status struct {
+	Kind          string     `json:"kind"`
+	Interval      string     `json:"interval"`
+	LastHeartbeat *time.Time `json:"last_heartbeat"`
+	Timeouts      int        `json:"timeouts"`
+}
+
+// Watchdog waits for a heartbeat every Interval, after waiting InitialDelay
+// for the first one. When no heartbeat arrives in time, OnTimeout is called
+// and the watchdog waits no more until it's started again.
+type Watchdog struct {
+	Kind         string
+	Path         string // heartbeat file or socket path
+	URL          string // heartbeat http endpoint
+	Interval     time.Duration
+	InitialDelay time.Duration
+	// UID and GID own the heartbeat file or socket, so an unprivileged
+	// service can write to them. -1 keeps the operator user or group.
+	UID, GID  int
+	OnTimeout func()
+
+	mu       sync.RWMutex // guards every field below
+	lastBeat time.Time
+	timeouts int
+	timer    *time.Timer // nil if not waiting for a heartbeat
+	deadline time.Time   // when the timer expires, moved by every heartbeat
+	stop     chan struct{}
+	conn     *net.UnixConn
+}
+
+// New returns a watchdog of the given kind, owned by the operator user.
+func New(kind string, interval time.Duration) *Watchdog {
+	return &Watchdog{Kind: kind, Interval: interval, UID: -1, GID: -1}
+}
+
+// MarshalJSON implements json marshal interface
+func (w *Watchdog) MarshalJSON() ([]byte, error) {
+	return json.Marshal(w.Status())
+}
+
+// Status returns the watchdog state.
+func (w *Watchdog) Status() Status {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	s := Status{Kind: w.Kind, Interval: w.Interval.String(), Timeouts: w.timeouts}
+	if !w.lastBeat.IsZero() {
+		t := w.lastBeat
+		s.LastHeartbeat = &t
+	}
+
+	return s
+}
+
+// Env returns the environment variables that tell the service how to send its
+// heartbeats.
+func (w *Watchdog) Env() []string {
+	env := []string{EnvInterval + "=" + strconv.FormatInt(int64(w.Interval/time.Microsecond), 10)}
+	switch w.Kind {
+	case KindFile:
+		env = append(env, EnvFile+"="+w.Path)
+	case KindSocket:
+		env = append(env, EnvSocket+"="+w.Path)
+	case KindHTTP:
+		env = append(env, EnvURL+"="+w.URL)
+	}
+
+	return env
+}
+
+// Start prepares the heartbeat file or socket and starts waiting for the first
+// heartbeat. If the watchdog is already started, it is restarted.
+func (w *Watchdog) Start() error {
+	w.Stop()
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	stop := make(chan struct{})
+	switch w.Kind {
+	case KindFile:
+		modTime, err := w.createFile()
+		if err != nil {
+			return err
+		}
+		go w.poll(stop, modTime)
+	case KindSocket:
+		conn, err := w.listen()
+		if err != nil {
+			return err
+		}
+		w.conn = conn
+		go w.read(conn)
+	case KindHTTP:
+		// heartbeats are received by the operator http server.
+	default:
+		return fmt.Errorf("watchdog: unknown kind %q", w.Kind)
+	}
+
+	w.stop = stop
+	w.lastBeat = time.Time{}
+	w.deadline = time.Now().Add(w.InitialDelay + w.Interval)
+	w.timer = time.AfterFunc(w.InitialDelay+w.Interval, func() { w.expired(stop) })
+
+	return nil
+}
+
+// Stop stops waiting for heartbeats and closes the heartbeat socket.
+func (w *Watchdog) Stop() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.stop == nil {
+		return
+	}
+
+	close(w.stop)
+	w.stop = nil
+	if w.timer != nil {
+		w.timer.Stop()
+		w.timer = nil
+	}
+	if w.conn != nil {
+		w.conn.Close()
+		w.conn = nil
+		os.Remove(w.Path)
+	}
+}
+
+// Beat records a heartbeat, so the watchdog waits another interval. It returns
+// an error if the watchdog is not started.
+func (w *Watchdog) Beat() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.stop == nil {
+		return fmt.Errorf("watchdog: not started")
+	}
+
+	w.lastBeat = time.Now()
+	if w.timer != nil {
+		w.deadline = w.lastBeat.Add(w.Interval)
+		w.timer.Reset(w.Interval)
+	}
+
+	return nil
+}
+
+// expired is called by the timer. A heartbeat can arrive while it's already
+// firing, then the deadline moved and the timer was reset, so it's ignored.
+func (w *Watchdog) expired(stop chan struct{}) {
+	w.mu.Lock()
+	if w.stop != stop || w.timer == nil || time.Now().Before(w.deadline) {
+		w.mu.Unlock()
+		return
+	}
+	w.timer = nil
+	w.timeouts++
+	w.mu.Unlock()
+
+	if w.OnTimeout != nil {
+		w.OnTimeout()
+	}
+}
+
+// createFile creates the heartbeat file, if it does not exist, and returns its
+// modification time.
+func (w *Watchdog) createFile() (time.Time, error) {
+	f, err := os.OpenFile(w.Path, os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return time.Time{}, err
+	}
+	f.Close()
+
+	if err := os.Chown(w.Path, w.UID, w.GID); err != nil {
+		return time.Time{}, err
+	}
+
+	info, err := os.Stat(w.Path)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return info.ModTime(), nil
+}
+
+// poll records a heartbeat every time the heartbeat file modification time
+// changes.
+func (w *Watchdog) poll(stop chan struct{}, modTime time.Time) {
+	interval := w.Interval / 4
+	if interval < minPollInterval {
+		interval = minPollInterval
+	}
+
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-tick.C:
+		}
+
+		info, err := os.Stat(w.Path)
+		if err != nil || !info.ModTime().After(modTime) {
+			continue
+		}
+
+		modTime = info.ModTime()
+		w.Beat()
+	}
+}
+
+// listen creates the heartbeat socket, removing the one left by a previous
+// operator run.
+func (w *Watchdog) listen() (*net.UnixConn, error) {
+	os.Remove(w.Path)
+
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: w.Path, Net: "unixgram"})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.Chown(w.Path, w.UID, w.GID); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return conn, nil
+}
+
+// read records a heartbeat for every datagram received, until the socket is
+// closed.
+func (w *Watchdog) read(conn *net.UnixConn) {
+	buf := make([]byte, 64)
+	for {
+		if _, _, err := conn.ReadFrom(buf); err != nil {
+			return
+		}
+
+		w.Beat()
+	}
+}